@@ -3,38 +3,140 @@ package config
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"path"
 	"sort"
+	"strconv"
 
 	"github.com/aus-hawk/estragon/env"
 	"gopkg.in/yaml.v3"
 )
 
+// A schema is the structure of estragon.yaml. The lint tags describe the values
+// for Lint. "env" means that the keys of a map are environment keys, "values"
+// that the values are lists of environment strings, "nested-env" that the keys
+// of the maps in a map are environment keys, "key" that the value is an
+// environment key, "method" that the value is a method, "link-style" that the
+// value is a link style, "mode" that the value is an octal file mode, and
+// "paths" that the strings within the value can use environment variables.
 type schema struct {
-	Common       common              `yaml:",inline"`
-	CheckCmd     map[string][]string `yaml:"check-cmd"`
-	InstallCmd   map[string][]string `yaml:"install-cmd"`
-	Validate     map[string][]string
-	Environments map[string]common
-	Packages     map[string]map[string][]string
+	Common       common                         `yaml:",inline"`
+	CheckCmd     map[string][]string            `yaml:"check-cmd" lint:"env"`
+	InstallCmd   map[string][]string            `yaml:"install-cmd" lint:"env"`
+	Validate     map[string][]string            `lint:"env,values"`
+	Environments map[string]common              `lint:"env"`
+	Packages     map[string]map[string][]string `lint:"nested-env"`
 	Dots         map[string]dot
+	Merge        *bool
+	Profiles     map[string]string
+	Include      []string
+	DotsDir      string `yaml:"dots-dir"`
+	Ignore       []string
+
+	// unextended are the dots as they were defined, before the dots they
+	// extend were merged into them. It is nil if no dot extends another.
+	unextended map[string]dot
 }
 
 type dot struct {
-	Common       common `yaml:",inline"`
-	Environments map[string]common
-	Rules        map[string]map[string]string
-	Deploy       map[string][][]string
+	Common       common                            `yaml:",inline"`
+	Environments map[string]common                 `lint:"env"`
+	Rules        map[string]map[string]ruleTargets `lint:"env,paths"`
+	Deploy       map[string][][]string             `lint:"env,paths"`
 	Packages     map[string]string
+	Merge        *bool
+	Extends      []string
+	Requires     []string
+	Tags         []string
+	When         string   `lint:"key"`
+	Unless       string   `lint:"key"`
+	RequiresCmd  []string `yaml:"requires-cmd"`
+	RequiresPath []string `yaml:"requires-path" lint:"paths"`
+	Source       string
+	Ignore       []string
+	Mode         string `lint:"mode"`
+	Owner        string
+	Group        string
 }
 
-type common struct {
-	Method    string
-	Root      string
+// A rule is where a file is deployed, which is either just its target or an
+// object that also overrides settings of the dot for the files it places.
+type rule struct {
+	Target    string
+	Method    string `lint:"method"`
+	Mode      string `lint:"mode"`
+	Owner     string
+	Group     string
 	DotPrefix *bool `yaml:"dot-prefix,omitempty"`
 }
 
+func (r *rule) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&r.Target)
+	}
+
+	// Without a target, the files would silently not be deployed, which
+	// is only meant to happen when the target is explicitly empty.
+	hasTarget := false
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		hasTarget = hasTarget || n.Content[i].Value == "target"
+	}
+	if n.Kind == yaml.MappingNode && !hasTarget {
+		return fmt.Errorf("line %d: rule has no target", n.Line)
+	}
+
+	type plainRule rule
+	return n.Decode((*plainRule)(r))
+}
+
+// ruleTargets are the targets of a rule, which is either a single target or a
+// list of them that the files are deployed to.
+type ruleTargets []rule
+
+func (r *ruleTargets) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.SequenceNode {
+		return n.Decode((*[]rule)(r))
+	}
+	var single rule
+	err := n.Decode(&single)
+	*r = ruleTargets{single}
+	return err
+}
+
+// options returns the settings of the dot that the rule overrides, and if it
+// overrides any of them.
+func (r rule) options() (RuleOptions, bool) {
+	o := RuleOptions{r.Method, r.Mode, r.Owner, r.Group, r.DotPrefix}
+	return o, o != RuleOptions{}
+}
+
+type common struct {
+	Method    string `lint:"method"`
+	Root      string `lint:"paths"`
+	DotPrefix *bool  `yaml:"dot-prefix,omitempty"`
+	LinkStyle string `yaml:"link-style" lint:"link-style"`
+	Fold      *bool  `yaml:"fold,omitempty"`
+}
+
+// Methods are the valid values of the method setting.
+var Methods = []string{"deep", "shallow", "copy", "hardlink", "none"}
+
+// LinkStyles are the valid values of the link style setting.
+var LinkStyles = []string{"absolute", "relative"}
+
+// ParseMode parses the octal file permissions `s`, like "0600" or "755". If
+// `s` isn't an octal number from 0 to 0777, a non-nil error is returned.
+func ParseMode(s string) (fs.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("Invalid mode %q", s)
+	}
+	return fs.FileMode(m), nil
+}
+
 type EnvSelector interface {
 	Select(keys []string) (key string, fields []string)
+	SelectAll(keys []string) (matched []string, fields [][]string)
 	Matches(string) bool
 }
 
@@ -46,24 +148,139 @@ type Config struct {
 }
 
 // NewConfig creates a config based on the contents of the YAML content `in`,
-// and the Environment `e`. If something goes wrong parsing `in`, err is
-// non-nil.
+// and the Environment `e`. If something goes wrong parsing `in` or resolving
+// the dots that extend other dots, err is non-nil.
 func NewConfig(in []byte, s EnvSelector) (c Config, err error) {
 	err = yaml.Unmarshal(in, &c.schema)
+	if err != nil {
+		return
+	}
+	err = c.schema.resolveExtends()
 	c.selector = s
 	return
 }
 
-// AllDots returns the slice of all dots that are defined within the config.
+// WithSelector returns a copy of the config that selects environment keys with
+// `s` instead of the selector it was created with.
+func (c Config) WithSelector(s EnvSelector) Config {
+	c.selector = s
+	return c
+}
+
+// Profiles returns the map of named environment strings defined in the config.
+func (c Config) Profiles() map[string]string {
+	return c.schema.Profiles
+}
+
+// AllDots returns the slice of all dots that are defined within the config and
+// apply to the environment.
 func (c Config) AllDots() []string {
 	d := make([]string, 0, len(c.schema.Dots))
 	for k := range c.schema.Dots {
-		d = append(d, k)
+		if ok, _ := c.Applies(k); ok {
+			d = append(d, k)
+		}
 	}
 	sort.Strings(d)
 	return d
 }
 
+// DefinedDots returns the slice of all dots that are defined within the
+// config, including the ones that don't apply to the environment.
+func (c Config) DefinedDots() []string {
+	return mapKeys(c.schema.Dots)
+}
+
+// Applies reports if the dot `dotName` applies to the environment, which it
+// does unless it has a when key that doesn't match the environment or an
+// unless key that does. If it doesn't apply, the reason says why.
+func (c Config) Applies(dotName string) (ok bool, reason string) {
+	dot := c.schema.Dots[dotName]
+	if dot.When != "" && !c.selector.Matches(dot.When) {
+		return false, fmt.Sprintf(
+			"its when key %q doesn't match the environment",
+			dot.When,
+		)
+	}
+	if dot.Unless != "" && c.selector.Matches(dot.Unless) {
+		return false, fmt.Sprintf(
+			"its unless key %q matches the environment",
+			dot.Unless,
+		)
+	}
+	return true, ""
+}
+
+// Tags returns the tags of the dot `dotName`.
+func (c Config) Tags(dotName string) []string {
+	return c.schema.Dots[dotName].Tags
+}
+
+// Source returns the directory containing the files of the dot `dotName`,
+// relative to the directory of the config and separated by slashes. It is the
+// source set on the dot if there is one, or the directory named after the dot
+// in the dots directory otherwise.
+func (c Config) Source(dotName string) string {
+	return c.schema.source(dotName)
+}
+
+func (s schema) source(dotName string) string {
+	if src := s.Dots[dotName].Source; src != "" {
+		return path.Clean(src)
+	}
+	return path.Join(s.DotsDir, dotName)
+}
+
+// Overlays returns the environment keys in `keys` that match the environment,
+// from least to most specific. The keys are the suffixes of the overlay
+// directories of a dot, which are named after its source followed by an @ and
+// an environment key.
+func (c Config) Overlays(keys []string) []string {
+	matched, _ := c.selector.SelectAll(keys)
+	return matched
+}
+
+// SelectAlternate returns the most specific of the environment keys `keys` of
+// the alternates of a file that matches the environment. If none of them match,
+// ok is false.
+func (c Config) SelectAlternate(keys []string) (key string, ok bool) {
+	matched, _ := c.selector.SelectAll(keys)
+	if len(matched) == 0 {
+		return "", false
+	}
+	return matched[len(matched)-1], true
+}
+
+// Guards returns the commands that have to be installed and the paths that
+// have to exist for the dot `dotName` to be installed or deployed.
+func (c Config) Guards(dotName string) (cmds, paths []string) {
+	dot := c.schema.Dots[dotName]
+	return dot.RequiresCmd, dot.RequiresPath
+}
+
+// Ignore returns the patterns of the files that the dot `dotName` ignores,
+// which are the patterns ignored by every dot followed by its own.
+func (c Config) Ignore(dotName string) []string {
+	patterns := make([]string, 0)
+	patterns = append(patterns, c.schema.Ignore...)
+	return append(patterns, c.schema.Dots[dotName].Ignore...)
+}
+
+// TaggedDots returns the dots in the config that have the tag `tag`, in sorted
+// order.
+func (c Config) TaggedDots(tag string) []string {
+	d := make([]string, 0)
+	for _, name := range c.DefinedDots() {
+		for _, t := range c.schema.Dots[name].Tags {
+			if t == tag {
+				d = append(d, name)
+				break
+			}
+		}
+	}
+	return d
+}
+
 // ValidateEnv checks the environment string against each validation set in the
 // configuration. It returns non-nil if validation fails.
 func (c Config) ValidateEnv() error {
@@ -91,19 +308,24 @@ func (c Config) envMatchesAny(l []string) bool {
 // CheckCmd returns the check command that matches the environment. If none of
 // the environments match, nil is returned.
 func (c Config) CheckCmd() []string {
-	checkers := c.schema.CheckCmd
-	key, _ := c.selector.Select(mapKeys(checkers))
-	cmd, _ := checkers[key]
-	return cmd
+	return c.selectCmd(c.schema.CheckCmd)
 }
 
 // InstallCmd returns the install command that matches the environment. If none
 // of the environments match, nil is returned.
 func (c Config) InstallCmd() []string {
-	installers := c.schema.InstallCmd
-	key, _ := c.selector.Select(mapKeys(installers))
-	cmd, _ := installers[key]
-	return cmd
+	return c.selectCmd(c.schema.InstallCmd)
+}
+
+// selectCmd returns the command in `cmds` that matches the environment, with
+// the submatches of the environment key substituted into its arguments.
+func (c Config) selectCmd(cmds map[string][]string) []string {
+	key, fields := c.selector.Select(mapKeys(cmds))
+	cmd, ok := cmds[key]
+	if !ok {
+		return nil
+	}
+	return replaceAll(env.NewMatch(key, fields).Replace, cmd)
 }
 
 // A Package represents a single key-value pair in the package map of a dot. The
@@ -125,12 +347,13 @@ func (c Config) Packages(dotName string) ([]Package, error) {
 		return nil, errors.New("Dot " + dotName + " not in config")
 	}
 
+	merge := c.merges(dot)
 	packages := make([]Package, 0, len(dot.Packages))
 	for name, desc := range dot.Packages {
 		pkg := Package{
 			Name: name,
 			Desc: desc,
-			List: c.expandPackage(name),
+			List: c.expandPackage(name, merge),
 		}
 		packages = append(packages, pkg)
 	}
@@ -138,37 +361,63 @@ func (c Config) Packages(dotName string) ([]Package, error) {
 	return packages, nil
 }
 
-func (c Config) expandPackage(pkgName string) []string {
+func (c Config) expandPackage(pkgName string, merge bool) []string {
 	packages, ok := c.schema.Packages[pkgName]
 	if !ok {
 		// The package is not expanded in any way.
 		return []string{pkgName}
 	}
 
-	key, fields := c.selector.Select(mapKeys(packages))
-
-	pkgList, ok := packages[key]
-	if !ok {
+	selected := c.selectKeys(mapKeys(packages), merge)
+	if len(selected) == 0 {
 		// The package is not expanded under the current environment.
 		return []string{pkgName}
 	}
 
-	match := env.NewMatch(key, fields)
-	realPkgs := make([]string, 0, len(pkgList))
-	for _, pkg := range pkgList {
-		realPkg := match.Replace(pkg)
-		realPkgs = append(realPkgs, realPkg)
+	seen := make(map[string]struct{})
+	realPkgs := make([]string, 0)
+	for _, sel := range selected {
+		match := env.NewMatch(sel.key, sel.fields)
+		for _, pkg := range packages[sel.key] {
+			realPkg := match.Replace(pkg)
+			if _, ok := seen[realPkg]; !ok {
+				seen[realPkg] = struct{}{}
+				realPkgs = append(realPkgs, realPkg)
+			}
+		}
 	}
 
 	return realPkgs
 }
 
+// RuleOptions are the settings of a dot that a rule overrides for the files it
+// places. Empty settings are not overridden.
+type RuleOptions struct {
+	Method    string
+	Mode      string
+	Owner     string
+	Group     string
+	DotPrefix *bool
+}
+
+// A RuleTarget is one of the targets of a rule that deploys files to several
+// places, along with the settings it overrides for them.
+type RuleTarget struct {
+	Target string
+	RuleOptions
+}
+
 // A DotConfig holds the most specific settings that can be applied to the
 // installation of dotfiles as specified by a dot configuration in the config.
 // The Rules are set according to the configuration and the environment, and are
-// exclusive to the dot it is a config of. The Method, Root, and DotPrefix are
-// values that can be set in multiple places, with the more specific
-// configurations taking precedence over the more general ones.
+// exclusive to the dot it is a config of. The RuleOptions are the settings that
+// the Rules with the same keys override, if they override any. The FanOut are
+// the targets after the first of the rules with several of them. The Mode,
+// Owner, and Group are given to the files of the dot and the directories
+// created for them, and are empty if they aren't set. The Method, Root,
+// DotPrefix, LinkStyle, and Fold are values that can be set in multiple places,
+// with the more specific configurations taking precedence over the more general
+// ones.
 //
 // From specific to general: environment settings within the dot config, the
 // values of the dot config itself, the environment settings of the global
@@ -178,63 +427,195 @@ type DotConfig struct {
 	Root         string
 	DotPrefix    bool
 	dotPrefixSet bool
+	LinkStyle    string
+	Fold         bool
+	foldSet      bool
 	Rules        map[string]string
+	RuleOptions  map[string]RuleOptions
+	FanOut       map[string][]RuleTarget
+	Mode         string
+	Owner        string
+	Group        string
 	Deploy       [][]string
 }
 
 // Get the DotConfig associated with a specific dot. If the dot does not exist
 // in the config, it has all of the globally specified defaults.
+//
+// If the dot is in merge mode, every matching key of the environment-keyed
+// settings contributes to the DotConfig instead of just one, from least to
+// most specific. More specific rules override less specific ones per file,
+// deploy commands are concatenated, and more specific common settings take
+// precedence over less specific ones.
 func (c Config) DotConfig(dotName string) (d DotConfig) {
 	// The zero value is fine to use.
 	dot := c.schema.Dots[dotName]
+	merge := c.merges(dot)
 
-	// Rules are only set in one place.
-	key, fields := c.selector.Select(mapKeys(dot.Rules))
-	envRules, ok := dot.Rules[key]
-	if ok {
-		templatedRules := make(map[string]string)
-		match := env.NewMatch(key, fields)
-		for k, v := range envRules {
+	// Rules are only set in the dot itself.
+	for _, sel := range c.selectKeys(mapKeys(dot.Rules), merge) {
+		if d.Rules == nil {
+			d.Rules = make(map[string]string)
+		}
+		match := env.NewMatch(sel.key, sel.fields)
+		for k, targets := range dot.Rules[sel.key] {
 			k = match.Replace(k)
-			templatedRules[k] = v
+			var v rule
+			if len(targets) > 0 {
+				v = targets[0]
+			}
+			d.Rules[k] = match.ReplacePath(v.Target)
+
+			delete(d.FanOut, k)
+			for i := 1; i < len(targets); i++ {
+				if d.FanOut == nil {
+					d.FanOut = make(map[string][]RuleTarget)
+				}
+				o, _ := targets[i].options()
+				target := match.ReplacePath(targets[i].Target)
+				d.FanOut[k] = append(d.FanOut[k], RuleTarget{target, o})
+			}
+
+			if o, ok := v.options(); ok {
+				if d.RuleOptions == nil {
+					d.RuleOptions = make(map[string]RuleOptions)
+				}
+				d.RuleOptions[k] = o
+			} else {
+				// A more specific rule without options replaces one
+				// with them.
+				delete(d.RuleOptions, k)
+			}
 		}
-		d.Rules = templatedRules
 	}
 
-	// Deploy commands are also only set in one place.
-	key, _ = c.selector.Select(mapKeys(dot.Deploy))
-	envDeploy, ok := dot.Deploy[key]
-	if ok {
-		d.Deploy = envDeploy
-	}
+	// So are the mode, owner, and group of the files.
+	d.Mode = dot.Mode
+	d.Owner = dot.Owner
+	d.Group = dot.Group
 
-	// Apply common config from dot-specific environment settings.
-	key, _ = c.selector.Select(mapKeys(dot.Environments))
-	commonConf, ok := dot.Environments[key]
-	if ok {
-		d = applyCommonDotConfig(commonConf, d)
+	// Deploy commands are also only set in the dot itself.
+	for _, sel := range c.selectKeys(mapKeys(dot.Deploy), merge) {
+		match := env.NewMatch(sel.key, sel.fields)
+		for _, cmd := range dot.Deploy[sel.key] {
+			d.Deploy = append(d.Deploy, replaceAll(match.ReplacePath, cmd))
+		}
 	}
 
-	// Apply common config from dot settings.
-	d = applyCommonDotConfig(dot.Common, d)
-
-	// Apply common config from environment-specific global settings.
-	key, _ = c.selector.Select(mapKeys(c.schema.Environments))
-	commonConf, ok = c.schema.Environments[key]
-	if ok {
-		d = applyCommonDotConfig(commonConf, d)
+	for _, layer := range c.commonLayers(dotName, dot, merge) {
+		d = applyCommonDotConfig(layer.common, d)
 	}
 
-	// Apply common config from global settings.
-	d = applyCommonDotConfig(c.schema.Common, d)
-
 	if !d.dotPrefixSet {
 		d.DotPrefix = true
 	}
+	if d.LinkStyle == "" {
+		d.LinkStyle = "absolute"
+	}
 
 	return
 }
 
+// A commonLayer is a place in the config where common settings of a dot can
+// come from. If the layer is keyed by environment, the sel is the selected key.
+type commonLayer struct {
+	location string
+	sel      *selection
+	common   common
+}
+
+// commonLayers returns the places that the common settings of a dot come from,
+// ordered from the highest priority to the lowest.
+func (c Config) commonLayers(dotName string, dot dot, merge bool) []commonLayer {
+	// Common config from dot-specific environment settings, and then from
+	// dot settings.
+	var layers []commonLayer
+	if c.schema.unextended == nil {
+		layers = c.envCommonLayers(
+			"dots."+dotName+".environments",
+			dot.Environments,
+			merge,
+		)
+		layers = append(layers, commonLayer{"dots." + dotName, nil, dot.Common})
+	} else {
+		layers = c.extendedCommonLayers(dotName, dot, merge)
+	}
+
+	// Common config from environment-specific global settings.
+	layers = append(layers, c.envCommonLayers(
+		"environments",
+		c.schema.Environments,
+		merge,
+	)...)
+
+	// Common config from global settings.
+	return append(layers, commonLayer{"global settings", nil, c.schema.Common})
+}
+
+// extendedCommonLayers returns the layers of the dot-specific settings of a dot
+// that other dots may have been merged into. Each of the dots it was merged
+// from has layers of its own, so that every setting is credited to the dot
+// that set it.
+func (c Config) extendedCommonLayers(
+	dotName string,
+	dot dot,
+	merge bool,
+) []commonLayer {
+	definers := c.schema.definers(dotName)
+
+	layers := make([]commonLayer, 0)
+	for _, envLayer := range c.envCommonLayers("", dot.Environments, merge) {
+		sel := envLayer.sel
+		for _, name := range definers {
+			commonConf, ok := c.schema.unextended[name].Environments[sel.key]
+			if !ok {
+				continue
+			}
+			commonConf.Root = env.NewMatch(sel.key, sel.fields).ReplacePath(
+				commonConf.Root,
+			)
+			layers = append(layers, commonLayer{
+				keyedLocation("dots."+name+".environments", sel.key),
+				sel,
+				commonConf,
+			})
+		}
+	}
+
+	for _, name := range definers {
+		layers = append(layers, commonLayer{
+			"dots." + name,
+			nil,
+			c.schema.unextended[name].Common,
+		})
+	}
+	return layers
+}
+
+// envCommonLayers returns the layers for the selected keys of `envs`, with the
+// more specific keys first.
+func (c Config) envCommonLayers(
+	location string,
+	envs map[string]common,
+	merge bool,
+) []commonLayer {
+	selected := c.selectKeys(mapKeys(envs), merge)
+	layers := make([]commonLayer, 0, len(selected))
+	for i := len(selected) - 1; i >= 0; i-- {
+		sel := selected[i]
+		commonConf := envs[sel.key]
+		commonConf.Root = env.NewMatch(sel.key, sel.fields).ReplacePath(
+			commonConf.Root,
+		)
+		layers = append(layers, commonLayer{
+			keyedLocation(location, sel.key),
+			&sel,
+			commonConf,
+		})
+	}
+	return layers
+}
+
 func applyCommonDotConfig(c common, d DotConfig) DotConfig {
 	if d.Method == "" {
 		d.Method = c.Method
@@ -246,13 +627,76 @@ func applyCommonDotConfig(c common, d DotConfig) DotConfig {
 		d.DotPrefix = *c.DotPrefix
 		d.dotPrefixSet = true
 	}
+	if d.LinkStyle == "" {
+		d.LinkStyle = c.LinkStyle
+	}
+	if !d.foldSet && c.Fold != nil {
+		d.Fold = *c.Fold
+		d.foldSet = true
+	}
 	return d
 }
 
-func mapKeys[K comparable, V any](m map[K]V) []K {
-	ks := make([]K, 0, len(m))
+// merges returns if every matching environment key should contribute to the
+// settings of the dot, instead of just one of them.
+func (c Config) merges(d dot) bool {
+	if d.Merge != nil {
+		return *d.Merge
+	}
+	return c.schema.Merge != nil && *c.schema.Merge
+}
+
+// keyedLocation returns the location of the value of an environment key within
+// a location in the config.
+func keyedLocation(location, key string) string {
+	return fmt.Sprintf("%s[%q]", location, key)
+}
+
+// A selection is a key of an environment-keyed map that was selected, along
+// with the fields of the environment that it matched.
+type selection struct {
+	key    string
+	fields []string
+}
+
+// selectKeys returns the keys in `keys` that are selected by the environment,
+// from least to most specific. Unless merge is true, at most one key is
+// selected.
+func (c Config) selectKeys(keys []string, merge bool) []selection {
+	if merge {
+		matched, fields := c.selector.SelectAll(keys)
+		selected := make([]selection, 0, len(matched))
+		for i, k := range matched {
+			selected = append(selected, selection{k, fields[i]})
+		}
+		return selected
+	}
+
+	key, fields := c.selector.Select(keys)
+	for _, k := range keys {
+		if k == key {
+			return []selection{{key, fields}}
+		}
+	}
+	return nil
+}
+
+// replaceAll applies `replace` to every string of `l`, returning a new slice.
+func replaceAll(replace func(string) string, l []string) []string {
+	replaced := make([]string, 0, len(l))
+	for _, s := range l {
+		replaced = append(replaced, replace(s))
+	}
+	return replaced
+}
+
+// mapKeys returns the keys of a map in sorted order, so that selecting from
+// them is deterministic.
+func mapKeys[V any](m map[string]V) []string {
+	ks := make([]string, 0, len(m))
 	for k := range m {
 		ks = append(ks, k)
 	}
+	sort.Strings(ks)
 	return ks
 }
@@ -3,6 +3,8 @@ package config
 import (
 	"reflect"
 	"testing"
+
+	"github.com/aus-hawk/estragon/env"
 )
 
 const goodYaml = `
@@ -31,6 +33,7 @@ environments:
     method: shallow
     root: home
     dot-prefix: false
+    link-style: relative
 
 packages:
   foo:
@@ -89,6 +92,7 @@ var goodSchema = schema{
 			Method:    "shallow",
 			Root:      "home",
 			DotPrefix: new(bool),
+			LinkStyle: "relative",
 		},
 	},
 	Packages: map[string]map[string][]string{
@@ -102,9 +106,9 @@ var goodSchema = schema{
 				Method: "copy",
 				Root:   "/test/dir/:",
 			},
-			Rules: map[string]map[string]string{
+			Rules: map[string]map[string]ruleTargets{
 				"test": {
-					"dir/file": "/new/loc/file",
+					"dir/file": {{Target: "/new/loc/file"}},
 				},
 			},
 			Packages: map[string]string{
@@ -117,9 +121,9 @@ var goodSchema = schema{
 			},
 		},
 		"templated": {
-			Rules: map[string]map[string]string{
+			Rules: map[string]map[string]ruleTargets{
 				"template-(.*)": {
-					"file-$1": "/outfile",
+					"file-$1": {{Target: "/outfile"}},
 				},
 			},
 		},
@@ -143,6 +147,12 @@ func (s mockEnvSelector) Select(keys []string) (key string, fields []string) {
 	return s.key, s.fields
 }
 
+func (s mockEnvSelector) SelectAll(
+	keys []string,
+) (matched []string, fields [][]string) {
+	return []string{s.key}, [][]string{s.fields}
+}
+
 func (s mockEnvSelector) Matches(e string) bool {
 	// Fail if a string contains 'x'
 	for _, c := range e {
@@ -161,6 +171,12 @@ func TestNewConfig(t *testing.T) {
 		err  bool
 	}{
 		{"Bad YAML", "]", Config{}, true},
+		{
+			"Rule without a target",
+			`dots: {a: {rules: {"": {b: {method: copy}}}}}`,
+			Config{},
+			true,
+		},
 		{
 			"Good YAML",
 			goodYaml,
@@ -208,6 +224,120 @@ func TestAllDots(t *testing.T) {
 	}
 }
 
+func TestTaggedDots(t *testing.T) {
+	c := Config{
+		schema: schema{
+			Dots: map[string]dot{
+				"i3":    {Tags: []string{"desktop"}},
+				"x11":   {Tags: []string{"heavy", "desktop"}},
+				"vim":   {Tags: []string{"cli"}},
+				"fonts": {},
+			},
+		},
+	}
+
+	expected := []string{"i3", "x11"}
+	actual := c.TaggedDots("desktop")
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %#v, got %#v", expected, actual)
+	}
+
+	if actual := c.TaggedDots("none"); len(actual) != 0 {
+		t.Errorf("Expected no dots, got %#v", actual)
+	}
+}
+
+func TestApplies(t *testing.T) {
+	c := Config{
+		schema: schema{
+			Dots: map[string]dot{
+				"gui":      {When: "desktop"},
+				"local":    {Unless: "server"},
+				"both":     {When: "linux", Unless: "server"},
+				"anywhere": {},
+			},
+		},
+		selector: env.NewEnvironment("linux server"),
+	}
+
+	tests := []struct {
+		dot     string
+		applies bool
+		reason  string
+	}{
+		{
+			"gui",
+			false,
+			`its when key "desktop" doesn't match the environment`,
+		},
+		{"local", false, `its unless key "server" matches the environment`},
+		{"both", false, `its unless key "server" matches the environment`},
+		{"anywhere", true, ""},
+		{"undefined", true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dot, func(t *testing.T) {
+			applies, reason := c.Applies(test.dot)
+			if applies != test.applies || reason != test.reason {
+				t.Errorf(
+					"Expected %v, %q, got %v, %q",
+					test.applies,
+					test.reason,
+					applies,
+					reason,
+				)
+			}
+		})
+	}
+
+	expectedAll := []string{"anywhere"}
+	if all := c.AllDots(); !reflect.DeepEqual(expectedAll, all) {
+		t.Errorf("Expected %#v, got %#v", expectedAll, all)
+	}
+}
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		desc     string
+		dotsDir  string
+		source   string
+		expected string
+	}{
+		{"Default", "", "", "dot"},
+		{"Dots directory", "dots", "", "dots/dot"},
+		{"Source", "dots", "desktop/i3", "desktop/i3"},
+		{"Unclean source", "", "desktop//i3/", "desktop/i3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			c := Config{
+				schema: schema{
+					DotsDir: test.dotsDir,
+					Dots:    map[string]dot{"dot": {Source: test.source}},
+				},
+			}
+			if source := c.Source("dot"); source != test.expected {
+				t.Errorf("Expected %q, got %q", test.expected, source)
+			}
+		})
+	}
+}
+
+func TestSelectAlternate(t *testing.T) {
+	c := Config{selector: env.NewEnvironment("distro:arch laptop work")}
+
+	key, ok := c.SelectAlternate([]string{"work", "distro:arch laptop", "home"})
+	if !ok || key != "distro:arch laptop" {
+		t.Errorf("Expected %q, got %q, %v", "distro:arch laptop", key, ok)
+	}
+
+	if key, ok := c.SelectAlternate([]string{"home"}); ok {
+		t.Errorf("Expected no alternate, got %q", key)
+	}
+}
+
 func TestValidateEnv(t *testing.T) {
 	tests := []struct {
 		desc           string
@@ -386,6 +516,7 @@ func TestDotConfigRealDotfile(t *testing.T) {
 				Root:         "/test/dir/:",
 				DotPrefix:    false,
 				dotPrefixSet: true,
+				LinkStyle:    "relative",
 				Rules: map[string]string{
 					"dir/file": "/new/loc/file",
 				},
@@ -400,6 +531,7 @@ func TestDotConfigRealDotfile(t *testing.T) {
 				Root:         "/test/dir/:",
 				DotPrefix:    true,
 				dotPrefixSet: false,
+				LinkStyle:    "absolute",
 				Rules:        nil,
 			},
 		},
@@ -412,6 +544,7 @@ func TestDotConfigRealDotfile(t *testing.T) {
 				Root:         "home",
 				DotPrefix:    false,
 				dotPrefixSet: true,
+				LinkStyle:    "relative",
 				Rules:        nil,
 			},
 		},
@@ -424,6 +557,7 @@ func TestDotConfigRealDotfile(t *testing.T) {
 				Root:         "xdg",
 				DotPrefix:    true,
 				dotPrefixSet: false,
+				LinkStyle:    "absolute",
 				Rules:        nil,
 			},
 		},
@@ -436,6 +570,7 @@ func TestDotConfigRealDotfile(t *testing.T) {
 				Root:         "xdg",
 				DotPrefix:    true,
 				dotPrefixSet: false,
+				LinkStyle:    "absolute",
 				Rules: map[string]string{
 					"file-xyz": "/outfile",
 				},
@@ -450,6 +585,7 @@ func TestDotConfigRealDotfile(t *testing.T) {
 				Root:         "home",
 				DotPrefix:    false,
 				dotPrefixSet: true,
+				LinkStyle:    "relative",
 				Rules:        nil,
 			},
 		},
@@ -462,6 +598,7 @@ func TestDotConfigRealDotfile(t *testing.T) {
 				Root:         "xdg",
 				DotPrefix:    true,
 				dotPrefixSet: false,
+				LinkStyle:    "absolute",
 				Rules:        nil,
 			},
 		},
@@ -474,6 +611,7 @@ func TestDotConfigRealDotfile(t *testing.T) {
 				Root:         "home",
 				DotPrefix:    false,
 				dotPrefixSet: true,
+				LinkStyle:    "relative",
 				Rules:        nil,
 				Deploy: [][]string{
 					{"command", "one"},
@@ -497,3 +635,198 @@ func TestDotConfigRealDotfile(t *testing.T) {
 		})
 	}
 }
+
+const mergeYaml = `
+method: deep
+root: "~"
+
+environments:
+  linux:
+    root: "/linux"
+  "linux laptop":
+    method: copy
+  laptop:
+    link-style: relative
+
+packages:
+  fonts:
+    linux: [font-linux, font-common]
+    laptop: [font-laptop, font-common]
+
+dots:
+  merged:
+    merge: true
+    rules:
+      linux:
+        a: "/linux/a"
+        b: "/linux/b"
+      "linux laptop":
+        b: "/laptop/b"
+      "laptop (h.*)":
+        "c-$1": "/laptop/c"
+    deploy:
+      "": [["first"]]
+      linux: [["second"]]
+      "linux laptop": [["third"]]
+    packages:
+      fonts: "Fonts"
+  unmerged:
+    rules:
+      linux:
+        a: "/linux/a"
+    packages:
+      fonts: "Fonts"
+`
+
+func TestMergedDotConfig(t *testing.T) {
+	e := env.NewEnvironment("linux laptop home")
+	c, err := NewConfig([]byte(mergeYaml), e)
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	expected := DotConfig{
+		Method:    "copy",
+		Root:      "/linux",
+		DotPrefix: true,
+		LinkStyle: "relative",
+		Rules: map[string]string{
+			"a":      "/linux/a",
+			"b":      "/laptop/b",
+			"c-home": "/laptop/c",
+		},
+		Deploy: [][]string{{"first"}, {"second"}, {"third"}},
+	}
+	actual := c.DotConfig("merged")
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	pkgs, err := c.Packages("merged")
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+	expectedPkgs := []Package{
+		{
+			"fonts",
+			"Fonts",
+			[]string{"font-laptop", "font-common", "font-linux"},
+		},
+	}
+	if !reflect.DeepEqual(expectedPkgs, pkgs) {
+		t.Errorf("expected %#v, got %#v", expectedPkgs, pkgs)
+	}
+
+	// Without merging, only a single key is used for packages.
+	pkgs, err = c.Packages("unmerged")
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+	expectedPkgs = []Package{
+		{"fonts", "Fonts", []string{"font-laptop", "font-common"}},
+	}
+	if !reflect.DeepEqual(expectedPkgs, pkgs) {
+		t.Errorf("expected %#v, got %#v", expectedPkgs, pkgs)
+	}
+}
+
+const templatedYaml = `
+check-cmd:
+  "distro:(?P<distro>.+)": ["${distro}-check", "$$1"]
+
+environments:
+  "distro:(?P<distro>.+)":
+    root: "~/${distro}"
+
+dots:
+  templated:
+    rules:
+      "distro:(?P<distro>.+) host:(.+)":
+        "${distro}.conf": "$HOME/.config/${distro}-$2.conf"
+    deploy:
+      "distro:(?P<distro>.+)":
+        - ["setup", "${distro}", "$HOME"]
+`
+
+func TestTemplatedDotConfig(t *testing.T) {
+	e := env.NewEnvironment("distro:arch host:box")
+	c, err := NewConfig([]byte(templatedYaml), e)
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	expected := DotConfig{
+		Root:      "~/arch",
+		DotPrefix: true,
+		LinkStyle: "absolute",
+		Rules: map[string]string{
+			"arch.conf": "$HOME/.config/arch-box.conf",
+		},
+		Deploy: [][]string{{"setup", "arch", "$HOME"}},
+	}
+	actual := c.DotConfig("templated")
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	expectedCmd := []string{"arch-check", "$1"}
+	if cmd := c.CheckCmd(); !reflect.DeepEqual(expectedCmd, cmd) {
+		t.Errorf("expected %#v, got %#v", expectedCmd, cmd)
+	}
+}
+
+const ruleOptionsYaml = `
+dots:
+  mixed:
+    merge: true
+    rules:
+      "linux":
+        a: {target: "~/a", method: copy, mode: "0600", owner: root}
+        b: {target: "~/b", dot-prefix: false}
+      "linux laptop":
+        b: "~/laptop/b"
+        c:
+          target: "~/c"
+          method: shallow
+        d:
+          - "~/d"
+          - "~/.config/d"
+          - {target: "~/copies/d", method: copy}
+`
+
+func TestRuleOptions(t *testing.T) {
+	e := env.NewEnvironment("linux laptop")
+	c, err := NewConfig([]byte(ruleOptionsYaml), e)
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	conf := c.DotConfig("mixed")
+	expectedRules := map[string]string{
+		"a": "~/a",
+		"b": "~/laptop/b",
+		"c": "~/c",
+		"d": "~/d",
+	}
+	if !reflect.DeepEqual(expectedRules, conf.Rules) {
+		t.Errorf("expected %#v, got %#v", expectedRules, conf.Rules)
+	}
+
+	expectedOptions := map[string]RuleOptions{
+		"a": {Method: "copy", Mode: "0600", Owner: "root"},
+		"c": {Method: "shallow"},
+	}
+	if !reflect.DeepEqual(expectedOptions, conf.RuleOptions) {
+		t.Errorf("expected %#v, got %#v", expectedOptions, conf.RuleOptions)
+	}
+
+	expectedFanOut := map[string][]RuleTarget{
+		"d": {
+			{Target: "~/.config/d"},
+			{Target: "~/copies/d", RuleOptions: RuleOptions{Method: "copy"}},
+		},
+	}
+	if !reflect.DeepEqual(expectedFanOut, conf.FanOut) {
+		t.Errorf("expected %#v, got %#v", expectedFanOut, conf.FanOut)
+	}
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"sort"
+
+	"github.com/aus-hawk/estragon/env"
+)
+
+// A Choice is a key of an environment-keyed map in the config along with the
+// fields of the environment that it matched.
+type Choice struct {
+	Key    string
+	Fields []string
+}
+
+// A KeyedExplanation explains how the keys of an environment-keyed map in the
+// config were chosen. The Location is where the map is in the config. The
+// Chosen keys are the ones whose values were used, from least to most specific.
+// The Lost keys are the ones that also matched the environment but were not
+// used.
+type KeyedExplanation struct {
+	Location string
+	Chosen   []Choice
+	Lost     []Choice
+}
+
+// A SettingExplanation explains where the value of a common setting came from.
+// The Location is where in the config the value was set, or empty if the value
+// is the default. The Choice is the environment key that the value was set
+// under, or nil if the location isn't keyed by environment.
+type SettingExplanation struct {
+	Value    string
+	Location string
+	Choice   *Choice
+}
+
+// A PackageExplanation explains the expansion of a single package of a dot. If
+// the package isn't in the global package map, the Location of the
+// KeyedExplanation is empty.
+type PackageExplanation struct {
+	Package
+	KeyedExplanation
+}
+
+// An Explanation explains why each of the settings of a dot were chosen. The
+// Extends are the dots that the dot inherits settings from, in the order they
+// are merged, and the settings are explained after merging them. The Config is
+// the DotConfig that the settings result in. The RuleKeys map the rules in the
+// Config to the environment keys they came from.
+type Explanation struct {
+	Dot        string
+	Extends    []string
+	Merge      bool
+	Config     DotConfig
+	Method     SettingExplanation
+	Root       SettingExplanation
+	DotPrefix  SettingExplanation
+	LinkStyle  SettingExplanation
+	Fold       SettingExplanation
+	Rules      KeyedExplanation
+	RuleKeys   map[string]string
+	Deploy     KeyedExplanation
+	CheckCmd   KeyedExplanation
+	InstallCmd KeyedExplanation
+	Packages   []PackageExplanation
+}
+
+// Explain explains how the settings of a dot `dotName` are chosen for the
+// current environment. If the dot does not exist, the settings are explained
+// as if it did with no settings of its own.
+func (c Config) Explain(dotName string) Explanation {
+	dot := c.schema.Dots[dotName]
+	merge := c.merges(dot)
+
+	e := Explanation{
+		Dot:     dotName,
+		Extends: c.schema.ancestors(dotName),
+		Merge:   merge,
+		Config:  c.DotConfig(dotName),
+	}
+
+	layers := c.commonLayers(dotName, dot, merge)
+	e.Method = explainSetting(e.Config.Method, layers, func(c common) bool {
+		return c.Method != ""
+	})
+	e.Root = explainSetting(e.Config.Root, layers, func(c common) bool {
+		return c.Root != ""
+	})
+	dotPrefix := "false"
+	if e.Config.DotPrefix {
+		dotPrefix = "true"
+	}
+	e.DotPrefix = explainSetting(dotPrefix, layers, func(c common) bool {
+		return c.DotPrefix != nil
+	})
+	e.LinkStyle = explainSetting(e.Config.LinkStyle, layers, func(c common) bool {
+		return c.LinkStyle != ""
+	})
+	fold := "false"
+	if e.Config.Fold {
+		fold = "true"
+	}
+	e.Fold = explainSetting(fold, layers, func(c common) bool {
+		return c.Fold != nil
+	})
+
+	e.Rules = c.explainKeyed(
+		"dots."+dotName+".rules",
+		mapKeys(dot.Rules),
+		merge,
+	)
+	e.RuleKeys = make(map[string]string)
+	for _, choice := range e.Rules.Chosen {
+		match := env.NewMatch(choice.Key, choice.Fields)
+		for k := range dot.Rules[choice.Key] {
+			// More specific keys override less specific ones.
+			e.RuleKeys[match.Replace(k)] = choice.Key
+		}
+	}
+
+	e.Deploy = c.explainKeyed(
+		"dots."+dotName+".deploy",
+		mapKeys(dot.Deploy),
+		merge,
+	)
+	e.CheckCmd = c.explainKeyed(
+		"check-cmd",
+		mapKeys(c.schema.CheckCmd),
+		false,
+	)
+	e.InstallCmd = c.explainKeyed(
+		"install-cmd",
+		mapKeys(c.schema.InstallCmd),
+		false,
+	)
+
+	pkgs, _ := c.Packages(dotName)
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].Name < pkgs[j].Name
+	})
+	for _, pkg := range pkgs {
+		pe := PackageExplanation{Package: pkg}
+		if aliases, ok := c.schema.Packages[pkg.Name]; ok {
+			pe.KeyedExplanation = c.explainKeyed(
+				"packages."+pkg.Name,
+				mapKeys(aliases),
+				merge,
+			)
+		}
+		e.Packages = append(e.Packages, pe)
+	}
+
+	return e
+}
+
+// explainSetting finds the first layer that sets a common setting according to
+// `isSet`, which is the layer that the value came from.
+func explainSetting(
+	value string,
+	layers []commonLayer,
+	isSet func(common) bool,
+) SettingExplanation {
+	for _, layer := range layers {
+		if isSet(layer.common) {
+			s := SettingExplanation{Value: value, Location: layer.location}
+			if layer.sel != nil {
+				s.Choice = &Choice{layer.sel.key, layer.sel.fields}
+			}
+			return s
+		}
+	}
+	return SettingExplanation{Value: value}
+}
+
+// explainKeyed explains which of the `keys` of the environment-keyed map at
+// `location` were chosen and which matched but were not.
+func (c Config) explainKeyed(
+	location string,
+	keys []string,
+	merge bool,
+) KeyedExplanation {
+	e := KeyedExplanation{Location: location}
+
+	chosen := make(map[string]struct{})
+	for _, sel := range c.selectKeys(keys, merge) {
+		e.Chosen = append(e.Chosen, Choice{sel.key, sel.fields})
+		chosen[sel.key] = struct{}{}
+	}
+
+	matched, fields := c.selector.SelectAll(keys)
+	for i, k := range matched {
+		if _, ok := chosen[k]; !ok {
+			e.Lost = append(e.Lost, Choice{k, fields[i]})
+		}
+	}
+
+	return e
+}
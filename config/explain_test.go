@@ -0,0 +1,138 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aus-hawk/estragon/env"
+)
+
+const explainYaml = `
+method: deep
+root: "~"
+
+environments:
+  laptop:
+    method: copy
+
+packages:
+  editor:
+    arch: [neovim]
+    "": [vim]
+
+dots:
+  nvim:
+    root: "~/.config/nvim"
+    environments:
+      "distro:(.+)":
+        root: "~/.config/nvim-$1"
+    rules:
+      laptop:
+        init.lua: "~/init.lua"
+      arch:
+        init.lua: "~/arch.lua"
+    packages:
+      editor: "The editor"
+      git: "Version control"
+`
+
+func TestExplain(t *testing.T) {
+	e := env.NewEnvironment("arch laptop distro:arch")
+	c, err := NewConfig([]byte(explainYaml), e)
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	explanation := c.Explain("nvim")
+
+	expectedMethod := SettingExplanation{
+		Value:    "copy",
+		Location: `environments["laptop"]`,
+		Choice:   &Choice{"laptop", []string{"laptop"}},
+	}
+	if !reflect.DeepEqual(expectedMethod, explanation.Method) {
+		t.Errorf(
+			"expected method to be %#v, got %#v",
+			expectedMethod,
+			explanation.Method,
+		)
+	}
+
+	expectedRoot := SettingExplanation{
+		Value:    "~/.config/nvim-arch",
+		Location: `dots.nvim.environments["distro:(.+)"]`,
+		Choice:   &Choice{"distro:(.+)", []string{"distro:arch"}},
+	}
+	if !reflect.DeepEqual(expectedRoot, explanation.Root) {
+		t.Errorf(
+			"expected root to be %#v, got %#v",
+			expectedRoot,
+			explanation.Root,
+		)
+	}
+
+	expectedDotPrefix := SettingExplanation{Value: "true"}
+	if !reflect.DeepEqual(expectedDotPrefix, explanation.DotPrefix) {
+		t.Errorf(
+			"expected dot prefix to be %#v, got %#v",
+			expectedDotPrefix,
+			explanation.DotPrefix,
+		)
+	}
+
+	expectedLinkStyle := SettingExplanation{Value: "absolute"}
+	if !reflect.DeepEqual(expectedLinkStyle, explanation.LinkStyle) {
+		t.Errorf(
+			"expected link style to be %#v, got %#v",
+			expectedLinkStyle,
+			explanation.LinkStyle,
+		)
+	}
+
+	expectedFold := SettingExplanation{Value: "false"}
+	if !reflect.DeepEqual(expectedFold, explanation.Fold) {
+		t.Errorf(
+			"expected fold to be %#v, got %#v",
+			expectedFold,
+			explanation.Fold,
+		)
+	}
+
+	expectedRules := KeyedExplanation{
+		Location: "dots.nvim.rules",
+		Chosen:   []Choice{{"arch", []string{"arch"}}},
+		Lost:     []Choice{{"laptop", []string{"laptop"}}},
+	}
+	if !reflect.DeepEqual(expectedRules, explanation.Rules) {
+		t.Errorf(
+			"expected rules to be %#v, got %#v",
+			expectedRules,
+			explanation.Rules,
+		)
+	}
+	if explanation.RuleKeys["init.lua"] != "arch" {
+		t.Errorf(`expected init.lua to come from "arch"`)
+	}
+
+	expectedPackages := []PackageExplanation{
+		{
+			Package{"editor", "The editor", []string{"neovim"}},
+			KeyedExplanation{
+				Location: "packages.editor",
+				Chosen:   []Choice{{"arch", []string{"arch"}}},
+				Lost:     []Choice{{"", nil}},
+			},
+		},
+		{
+			Package{"git", "Version control", []string{"git"}},
+			KeyedExplanation{},
+		},
+	}
+	if !reflect.DeepEqual(expectedPackages, explanation.Packages) {
+		t.Errorf(
+			"expected packages to be %#v, got %#v",
+			expectedPackages,
+			explanation.Packages,
+		)
+	}
+}
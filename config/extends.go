@@ -0,0 +1,218 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// resolveExtends replaces every dot that extends other dots with the result of
+// merging it over the dots it extends. The dots are merged in the order they
+// are listed, with later dots overriding earlier ones and the dot itself
+// overriding all of them. If a dot extends a dot that doesn't exist or extends
+// itself through any number of other dots, a non-nil error is returned.
+func (s *schema) resolveExtends() error {
+	resolved := make(map[string]dot, len(s.Dots))
+	var resolve func(name string, chain []string) (dot, error)
+	resolve = func(name string, chain []string) (dot, error) {
+		if d, ok := resolved[name]; ok {
+			return d, nil
+		}
+		for i, n := range chain {
+			if n == name {
+				cycle := append(chain[i:], name)
+				return dot{}, fmt.Errorf(
+					"Dot %q extends itself: %s",
+					name,
+					strings.Join(cycle, " -> "),
+				)
+			}
+		}
+
+		d := s.Dots[name]
+		var merged dot
+		for _, parentName := range d.Extends {
+			if _, ok := s.Dots[parentName]; !ok {
+				return dot{}, fmt.Errorf(
+					"Dot %q extends unknown dot %q",
+					name,
+					parentName,
+				)
+			}
+			parent, err := resolve(parentName, append(chain, name))
+			if err != nil {
+				return dot{}, err
+			}
+			merged = mergeDots(merged, parent)
+		}
+		merged = mergeDots(merged, d)
+
+		// Only the settings are inherited, the rest stays the dot's own.
+		d.Common = merged.Common
+		d.Environments = merged.Environments
+		d.Rules = merged.Rules
+		d.Deploy = merged.Deploy
+		d.Packages = merged.Packages
+		d.Merge = merged.Merge
+		d.Ignore = merged.Ignore
+		d.Mode = merged.Mode
+		d.Owner = merged.Owner
+		d.Group = merged.Group
+
+		resolved[name] = d
+		return d, nil
+	}
+
+	extends := false
+	for _, name := range mapKeys(s.Dots) {
+		_, err := resolve(name, nil)
+		if err != nil {
+			return err
+		}
+		extends = extends || len(s.Dots[name].Extends) > 0
+	}
+	if extends {
+		s.unextended = s.Dots
+	}
+	s.Dots = resolved
+	return nil
+}
+
+// definers returns the dot `name` and every dot it was merged from, ordered
+// from the one whose settings take precedence to the one whose settings are
+// overridden by all of the others.
+func (s schema) definers(name string) []string {
+	var order []string
+	var visit func(string)
+	visit = func(name string) {
+		for _, parent := range s.unextended[name].Extends {
+			visit(parent)
+		}
+		order = append(order, name)
+	}
+	visit(name)
+
+	// A dot merged more than once takes the precedence of its last merge.
+	seen := make(map[string]bool)
+	definers := make([]string, 0, len(order))
+	for i := len(order) - 1; i >= 0; i-- {
+		if !seen[order[i]] {
+			seen[order[i]] = true
+			definers = append(definers, order[i])
+		}
+	}
+	return definers
+}
+
+// ancestors returns every dot that the dot `name` inherits from, directly or
+// through other dots, in the order they are merged.
+func (s schema) ancestors(name string) []string {
+	seen := make(map[string]bool)
+	var l []string
+	var visit func(string)
+	visit = func(name string) {
+		for _, parent := range s.Dots[name].Extends {
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			visit(parent)
+			l = append(l, parent)
+		}
+	}
+	visit(name)
+	return l
+}
+
+// mergeDots returns the dot `base` with the settings of `over` merged on top.
+// Settings keyed by environment are merged per key, so `over` only replaces
+// the rules and environment settings that it sets itself, and the deploy
+// commands of the keys that it has. The ignore patterns of `over` are added
+// after those of `base`.
+func mergeDots(base, over dot) dot {
+	base.Common = mergeCommon(base.Common, over.Common)
+	if over.Merge != nil {
+		base.Merge = over.Merge
+	}
+	if over.Mode != "" {
+		base.Mode = over.Mode
+	}
+	if over.Owner != "" {
+		base.Owner = over.Owner
+	}
+	if over.Group != "" {
+		base.Group = over.Group
+	}
+
+	envs := make(map[string]common)
+	for k, v := range base.Environments {
+		envs[k] = v
+	}
+	for k, v := range over.Environments {
+		envs[k] = mergeCommon(envs[k], v)
+	}
+	base.Environments = nilIfEmpty(envs)
+
+	rules := make(map[string]map[string]ruleTargets)
+	for _, l := range []map[string]map[string]ruleTargets{base.Rules, over.Rules} {
+		for k, v := range l {
+			if rules[k] == nil {
+				rules[k] = make(map[string]ruleTargets)
+			}
+			for file, target := range v {
+				rules[k][file] = target
+			}
+		}
+	}
+	base.Rules = nilIfEmpty(rules)
+
+	deploy := make(map[string][][]string)
+	for _, l := range []map[string][][]string{base.Deploy, over.Deploy} {
+		for k, v := range l {
+			deploy[k] = v
+		}
+	}
+	base.Deploy = nilIfEmpty(deploy)
+
+	pkgs := make(map[string]string)
+	for _, l := range []map[string]string{base.Packages, over.Packages} {
+		for k, v := range l {
+			pkgs[k] = v
+		}
+	}
+	base.Packages = nilIfEmpty(pkgs)
+
+	if len(over.Ignore) > 0 {
+		ignore := append([]string(nil), base.Ignore...)
+		base.Ignore = append(ignore, over.Ignore...)
+	}
+
+	return base
+}
+
+// mergeCommon returns the common settings of `base` with the ones that are set
+// in `over` taking precedence.
+func mergeCommon(base, over common) common {
+	if over.Method != "" {
+		base.Method = over.Method
+	}
+	if over.Root != "" {
+		base.Root = over.Root
+	}
+	if over.DotPrefix != nil {
+		base.DotPrefix = over.DotPrefix
+	}
+	if over.LinkStyle != "" {
+		base.LinkStyle = over.LinkStyle
+	}
+	if over.Fold != nil {
+		base.Fold = over.Fold
+	}
+	return base
+}
+
+func nilIfEmpty[V any](m map[string]V) map[string]V {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aus-hawk/estragon/env"
+)
+
+const extendsYaml = `
+ignore: [".git/"]
+dots:
+  shell:
+    method: copy
+    link-style: relative
+    mode: "0600"
+    ignore: ["*.orig"]
+    root: "/shell"
+    rules:
+      "":
+        rc: "/shell/rc"
+        profile: "/shell/profile"
+    deploy:
+      "":
+        - ["shell", "deploy"]
+    packages:
+      shell: "The shell"
+  colors:
+    root: "/colors"
+    fold: true
+    packages:
+      colors: "The colors"
+  zsh:
+    extends: [shell, colors]
+    ignore: [README.md]
+    group: wheel
+    rules:
+      "":
+        rc: "/zsh/rc"
+    packages:
+      shell: "Zsh"
+`
+
+func TestExtends(t *testing.T) {
+	c, err := NewConfig([]byte(extendsYaml), env.NewEnvironment("test"))
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	expectedConf := DotConfig{
+		Method:    "copy",
+		Root:      "/colors",
+		DotPrefix: true,
+		LinkStyle: "relative",
+		Fold:      true,
+		foldSet:   true,
+		Rules: map[string]string{
+			"rc":      "/zsh/rc",
+			"profile": "/shell/profile",
+		},
+		Mode:   "0600",
+		Group:  "wheel",
+		Deploy: [][]string{{"shell", "deploy"}},
+	}
+	if conf := c.DotConfig("zsh"); !reflect.DeepEqual(expectedConf, conf) {
+		t.Errorf("expected %#v, got %#v", expectedConf, conf)
+	}
+
+	pkgs, err := c.Packages("zsh")
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+	descs := make(map[string]string)
+	for _, pkg := range pkgs {
+		descs[pkg.Name] = pkg.Desc
+	}
+	expectedDescs := map[string]string{"shell": "Zsh", "colors": "The colors"}
+	if !reflect.DeepEqual(expectedDescs, descs) {
+		t.Errorf("expected %v, got %v", expectedDescs, descs)
+	}
+
+	expectedIgnore := []string{".git/", "*.orig", "README.md"}
+	if ignore := c.Ignore("zsh"); !reflect.DeepEqual(expectedIgnore, ignore) {
+		t.Errorf("expected %v, got %v", expectedIgnore, ignore)
+	}
+
+	expectedExtends := []string{"shell", "colors"}
+	if extends := c.Explain("zsh").Extends; !reflect.DeepEqual(
+		expectedExtends,
+		extends,
+	) {
+		t.Errorf("expected %v, got %v", expectedExtends, extends)
+	}
+
+	// Inherited settings are explained as coming from the dot that set them.
+	e := c.Explain("zsh")
+	locations := map[string]SettingExplanation{
+		"dots.shell":  e.Method,
+		"dots.colors": e.Root,
+	}
+	for expected, s := range locations {
+		if s.Location != expected {
+			t.Errorf("expected %q, got %q", expected, s.Location)
+		}
+	}
+}
+
+func TestExtendsErrors(t *testing.T) {
+	tests := []struct {
+		desc string
+		yaml string
+		err  string
+	}{
+		{
+			"Cycle",
+			"dots: {a: {extends: [b]}, b: {extends: [c]}, c: {extends: [a]}}",
+			`Dot "a" extends itself: a -> b -> c -> a`,
+		},
+		{
+			"Self",
+			"dots: {a: {extends: [a]}}",
+			`Dot "a" extends itself: a -> a`,
+		},
+		{
+			"Unknown dot",
+			"dots: {a: {extends: [b]}}",
+			`Dot "a" extends unknown dot "b"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := NewConfig([]byte(test.yaml), env.NewEnvironment(""))
+			if err == nil {
+				t.Fatalf("expected error %q, got nil", test.err)
+			}
+			if err.Error() != test.err {
+				t.Errorf("expected error %q, got %q", test.err, err.Error())
+			}
+		})
+	}
+}
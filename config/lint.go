@@ -0,0 +1,675 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aus-hawk/estragon/env"
+	"gopkg.in/yaml.v3"
+)
+
+// A Problem is something wrong with a config found by Lint, along with the
+// file, line, and column where it was found.
+type Problem struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (p Problem) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Msg)
+}
+
+// Lint checks the YAML content of a config `in` for problems that parsing it
+// with NewConfig silently ignores or only reveals while deploying. `dirs` are
+// the names of the directories next to the config, which are compared against
+// the dots, and `lookupEnv` reports if an environment variable is set. The
+// problems are sorted by their position. If the content isn't valid YAML, a
+// non-nil error is returned instead.
+func Lint(
+	in []byte,
+	dirs []string,
+	lookupEnv func(string) bool,
+) ([]Problem, error) {
+	return lintFiles([]configFile{{in: in}}, dirList(dirs), lookupEnv)
+}
+
+// LintDir is like Lint, except that it checks every file that makes up the
+// config in `dir` as they would be loaded by LoadConfig, comparing the dots
+// against the directories in `dir` and the sources of the dots. The files of
+// the problems are relative to `dir`. Conflicts between the files are also
+// reported.
+func LintDir(dir string, lookupEnv func(string) bool) ([]Problem, error) {
+	files, err := configFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for i := range files {
+		files[i].path = relPath(dir, files[i].path)
+	}
+
+	return lintFiles(files, osDirTree(dir), lookupEnv)
+}
+
+// A lintedFile is a config file that has been parsed for linting.
+type lintedFile struct {
+	configFile
+	root *yaml.Node
+}
+
+func lintFiles(
+	files []configFile,
+	tree dirTree,
+	lookupEnv func(string) bool,
+) ([]Problem, error) {
+	l := linter{lookupEnv: lookupEnv}
+	m := schemaMerger{origins: make(map[string]string)}
+	linted := make([]lintedFile, 0, len(files))
+
+	for i, f := range files {
+		var doc yaml.Node
+		err := yaml.Unmarshal(f.in, &doc)
+		if err != nil {
+			return nil, l.fileError(f.path, err)
+		}
+		if len(doc.Content) == 0 {
+			continue
+		}
+		root := doc.Content[0]
+		l.file = f.path
+
+		var part schema
+		if f.dot != "" {
+			var d dot
+			err = root.Decode(&d)
+			part.Dots = map[string]dot{f.dot: d}
+			l.walk(root, reflect.TypeOf(d), nil)
+		} else {
+			err = root.Decode(&part)
+			l.walk(root, reflect.TypeOf(part), nil)
+		}
+		if err != nil {
+			return nil, l.fileError(f.path, err)
+		}
+
+		if i != 0 && part.Include != nil {
+			k, _ := mappingValue(root, "include")
+			l.report(k, "include can only be used in estragon.yaml")
+		}
+		err = m.merge(part, f.path)
+		if err != nil {
+			l.report(root, "%s", err)
+		}
+		linted = append(linted, lintedFile{f, root})
+	}
+
+	if len(linted) > 0 {
+		l.file = linted[0].path
+		err := m.schema.resolveExtends()
+		if err != nil {
+			l.report(linted[0].root, "%s", err)
+		}
+		c := Config{schema: m.schema}
+		_, err = c.DependencyOrder(mapKeys(m.schema.Dots))
+		if err != nil {
+			l.report(linted[0].root, "%s", err)
+		}
+	}
+
+	l.lintDots(linted, m.schema, tree)
+	l.lintPackages(linted, m.schema)
+
+	order := make(map[string]int)
+	for i, f := range files {
+		order[f.path] = i
+	}
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		pi, pj := l.problems[i], l.problems[j]
+		if pi.File != pj.File {
+			return order[pi.File] < order[pj.File]
+		} else if pi.Line != pj.Line {
+			return pi.Line < pj.Line
+		}
+		return pi.Column < pj.Column
+	})
+	return l.problems, nil
+}
+
+type linter struct {
+	lookupEnv func(string) bool
+	file      string
+	problems  []Problem
+}
+
+func (l *linter) report(n *yaml.Node, format string, a ...any) {
+	l.problems = append(l.problems, Problem{
+		l.file,
+		n.Line,
+		n.Column,
+		fmt.Sprintf(format, a...),
+	})
+}
+
+func (l *linter) fileError(file string, err error) error {
+	if file == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", file, err)
+}
+
+// walk checks a node against the type `t` it is decoded into. `groups` are the
+// names of the submatches of the environment key that the node is under, which
+// aren't environment variables.
+func (l *linter) walk(n *yaml.Node, t reflect.Type, groups map[string]bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := structFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			f, ok := fields[k.Value]
+			if !ok {
+				l.reportUnknownKey(k, fields)
+				continue
+			}
+			l.lintField(v, f, groups)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			l.walk(n.Content[i+1], t.Elem(), groups)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			// The targets of a rule can be a single target instead.
+			l.walk(n, t.Elem(), groups)
+			return
+		}
+		for _, item := range n.Content {
+			l.walk(item, t.Elem(), groups)
+		}
+	}
+}
+
+// lintField checks the value of a struct field according to its lint tag.
+func (l *linter) lintField(
+	n *yaml.Node,
+	f reflect.StructField,
+	groups map[string]bool,
+) {
+	tags := strings.Split(f.Tag.Get("lint"), ",")
+	has := func(tag string) bool {
+		for _, t := range tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if has("method") && n.Kind == yaml.ScalarNode && n.Value != "" {
+		if !isOneOf(n.Value, Methods) {
+			l.report(
+				n,
+				"Invalid method %q, must be one of %s",
+				n.Value,
+				strings.Join(Methods, ", "),
+			)
+		}
+	}
+
+	if has("link-style") && n.Kind == yaml.ScalarNode && n.Value != "" {
+		if !isOneOf(n.Value, LinkStyles) {
+			l.report(
+				n,
+				"Invalid link style %q, must be one of %s",
+				n.Value,
+				strings.Join(LinkStyles, ", "),
+			)
+		}
+	}
+
+	if has("mode") && n.Kind == yaml.ScalarNode && n.Value != "" {
+		if _, err := ParseMode(n.Value); err != nil {
+			l.report(n, "Invalid mode %q, must be an octal number", n.Value)
+		}
+	}
+
+	if has("key") && n.Kind == yaml.ScalarNode && !env.ValidateKey(n.Value) {
+		l.report(n, "Invalid environment key %q", n.Value)
+	}
+
+	if has("paths") && !has("env") {
+		l.lintEnvvars(n, groups)
+	}
+
+	if has("nested-env") && n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			l.lintEnvKeys(n.Content[i+1])
+		}
+	}
+
+	if !has("env") || n.Kind != yaml.MappingNode {
+		l.walk(n, f.Type, groups)
+		return
+	}
+
+	l.lintEnvKeys(n)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], n.Content[i+1]
+		if has("values") && v.Kind == yaml.SequenceNode {
+			for _, e := range v.Content {
+				if !env.ValidateKey(e.Value) {
+					l.report(e, "Invalid environment string %q", e.Value)
+				}
+			}
+		}
+
+		keyGroups := submatchNames(k.Value)
+		for g := range groups {
+			keyGroups[g] = true
+		}
+		if has("paths") {
+			l.lintEnvvars(v, keyGroups)
+		}
+		l.walk(v, f.Type.Elem(), keyGroups)
+	}
+}
+
+// lintEnvKeys checks that every key of a mapping node is an environment key.
+func (l *linter) lintEnvKeys(n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i < len(n.Content); i += 2 {
+		k := n.Content[i]
+		if !env.ValidateKey(k.Value) {
+			l.report(k, "Invalid environment key %q", k.Value)
+		}
+	}
+}
+
+var envvarRegexp = regexp.MustCompile(`\$(?:\{([^}]*)\}|([A-Za-z0-9_]+))`)
+
+// lintEnvvars reports every environment variable used in the scalars within a
+// node that is not set. Submatch references and escaped dollar signs are not
+// environment variables.
+func (l *linter) lintEnvvars(n *yaml.Node, groups map[string]bool) {
+	if n.Kind != yaml.ScalarNode {
+		for _, c := range n.Content {
+			l.lintEnvvars(c, groups)
+		}
+		return
+	}
+
+	s := strings.ReplaceAll(n.Value, "$$", "")
+	for _, m := range envvarRegexp.FindAllStringSubmatch(s, -1) {
+		name := m[1] + m[2]
+		if groups[name] || isNumber(name) {
+			continue
+		}
+		if l.lookupEnv != nil && !l.lookupEnv(name) {
+			l.report(n, "Environment variable %s is not set", name)
+		}
+	}
+}
+
+// lintDots compares the dots in the config against the directories next to it.
+func (l *linter) lintDots(files []lintedFile, s schema, tree dirTree) {
+	for _, f := range files {
+		l.file = f.path
+		if f.dot != "" {
+			l.lintSource(f.root, f.dot, s, tree)
+			l.lintRequires(f.root, s, tree)
+			l.lintModes(f.root, f.dot, s)
+			continue
+		}
+
+		_, dots := mappingValue(f.root, "dots")
+		if dots == nil {
+			continue
+		}
+		for i := 0; i < len(dots.Content); i += 2 {
+			k := dots.Content[i]
+			l.lintSource(k, k.Value, s, tree)
+			l.lintRequires(dots.Content[i+1], s, tree)
+			l.lintModes(dots.Content[i+1], k.Value, s)
+		}
+	}
+
+	if len(files) == 0 {
+		return
+	}
+	main := files[0]
+	l.file = main.path
+	n := main.root
+	if dotsKey, _ := mappingValue(main.root, "dots"); dotsKey != nil {
+		n = dotsKey
+	}
+	for _, d := range tree.dirs(s.DotsDir) {
+		dir := path.Join(s.DotsDir, d)
+		if base, key, ok := strings.Cut(d, "@"); ok {
+			// Overlay directories belong to the dot of their base.
+			if !env.ValidateKey(key) {
+				l.report(n, "Overlay directory %q has an invalid key", dir)
+			}
+			dir = path.Join(s.DotsDir, base)
+		}
+		used := false
+		for name := range s.Dots {
+			src := s.source(name)
+			used = used || src == dir || strings.HasPrefix(src, dir+"/")
+		}
+		if !used {
+			l.report(n, "Directory %q has no dot in the config", dir)
+		}
+	}
+}
+
+// lintModes reports the modes set in the dot `name`, defined at the node `n`,
+// that are never applied because every file they are for is linked. Links
+// don't have permissions of their own.
+func (l *linter) lintModes(n *yaml.Node, name string, s schema) {
+	d := s.Dots[name]
+	method := d.Common.Method
+	if method == "" {
+		method = s.Common.Method
+	}
+	linked := func(m string) bool {
+		return m == "deep" || m == "shallow"
+	}
+
+	// The dot's mode is applied if any of its files may be copied.
+	methods := []string{method}
+	for _, envs := range []map[string]common{d.Environments, s.Environments} {
+		for _, c := range envs {
+			methods = append(methods, c.Method)
+		}
+	}
+
+	_, rules := mappingValue(n, "rules")
+	if rules != nil && rules.Kind == yaml.MappingNode {
+		for i := 1; i < len(rules.Content); i += 2 {
+			files := rules.Content[i]
+			if files.Kind != yaml.MappingNode {
+				continue
+			}
+			for j := 1; j < len(files.Content); j += 2 {
+				targets := []*yaml.Node{files.Content[j]}
+				if files.Content[j].Kind == yaml.SequenceNode {
+					targets = files.Content[j].Content
+				}
+				for _, t := range targets {
+					ruleMethod := method
+					if _, m := mappingValue(t, "method"); m != nil {
+						ruleMethod = m.Value
+						methods = append(methods, m.Value)
+					}
+					_, mode := mappingValue(t, "mode")
+					if mode != nil && linked(ruleMethod) {
+						l.report(
+							mode,
+							"Mode %q is not applied to links",
+							mode.Value,
+						)
+					}
+				}
+			}
+		}
+	}
+
+	_, mode := mappingValue(n, "mode")
+	if mode == nil {
+		return
+	}
+	for _, m := range methods {
+		if m != "" && !linked(m) {
+			return
+		}
+	}
+	if linked(method) {
+		l.report(mode, "Mode %q is not applied to links", mode.Value)
+	}
+}
+
+// lintSource reports if the source directory of the dot `name`, defined at
+// the node `n`, doesn't exist or is outside of the config directory.
+func (l *linter) lintSource(n *yaml.Node, name string, s schema, tree dirTree) {
+	src := s.source(name)
+	if !filepath.IsLocal(filepath.FromSlash(src)) {
+		l.report(n, "Dot %q has source %q outside of the config", name, src)
+	} else if !tree.exists(src) && src == name {
+		l.report(n, "Dot %q has no directory", name)
+	} else if !tree.exists(src) {
+		l.report(n, "Dot %q has no directory %q", name, src)
+	}
+}
+
+// lintRequires reports the dots required by the dot config `n` that are
+// neither in the config nor a directory.
+func (l *linter) lintRequires(n *yaml.Node, s schema, tree dirTree) {
+	_, requires := mappingValue(n, "requires")
+	if requires == nil {
+		return
+	}
+	for _, r := range requires.Content {
+		_, ok := s.Dots[r.Value]
+		if !ok && !tree.exists(s.source(r.Value)) {
+			l.report(r, "Required dot %q is not in the config", r.Value)
+		}
+	}
+}
+
+// A dirTree tells the linter about the directories next to a config. The
+// directories are relative to the config and separated by slashes.
+type dirTree interface {
+	// dirs returns the names of the directories in `dir` that could be dots.
+	dirs(dir string) []string
+	exists(dir string) bool
+}
+
+// A dirList is a dirTree of just the directories next to the config.
+type dirList []string
+
+func (l dirList) dirs(dir string) []string {
+	if path.Clean(dir) != "." {
+		return nil
+	}
+	return l
+}
+
+func (l dirList) exists(dir string) bool {
+	for _, d := range l {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// An osDirTree is the dirTree of a directory on the filesystem.
+type osDirTree string
+
+func (t osDirTree) dirs(dir string) []string {
+	entries, err := os.ReadDir(t.join(dir))
+	if err != nil {
+		return nil
+	}
+
+	dirs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		} else if path.Clean(dir) == "." && name == "estragon.d" {
+			continue
+		}
+		dirs = append(dirs, name)
+	}
+	return dirs
+}
+
+func (t osDirTree) join(dir string) string {
+	return filepath.Join(string(t), filepath.FromSlash(dir))
+}
+
+func (t osDirTree) exists(dir string) bool {
+	info, err := os.Stat(t.join(dir))
+	return err == nil && info.IsDir()
+}
+
+// lintPackages reports the package aliases that no dot uses.
+func (l *linter) lintPackages(files []lintedFile, s schema) {
+	used := make(map[string]bool)
+	for _, d := range s.Dots {
+		for p := range d.Packages {
+			used[p] = true
+		}
+	}
+
+	for _, f := range files {
+		if f.dot != "" {
+			continue
+		}
+		_, pkgs := mappingValue(f.root, "packages")
+		if pkgs == nil {
+			continue
+		}
+
+		l.file = f.path
+		for i := 0; i < len(pkgs.Content); i += 2 {
+			k := pkgs.Content[i]
+			if !used[k.Value] {
+				l.report(k, "Package %q is not used by any dot", k.Value)
+			}
+		}
+	}
+}
+
+func (l *linter) reportUnknownKey(k *yaml.Node, fields map[string]reflect.StructField) {
+	best, bestDist := "", 3
+	for name := range fields {
+		if d := editDistance(k.Value, name); d < bestDist ||
+			(d == bestDist && name < best) {
+			best, bestDist = name, d
+		}
+	}
+
+	if best != "" {
+		l.report(k, "Unknown key %q, did you mean %q?", k.Value, best)
+	} else {
+		l.report(k, "Unknown key %q", k.Value)
+	}
+}
+
+// structFields returns the fields of a struct type by the name of their key in
+// YAML, including the fields of inlined structs.
+func structFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if strings.Contains(opts, "inline") {
+			for k, v := range structFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// mappingValue returns the key and value nodes of a key in a mapping node, or
+// nil if the key doesn't exist.
+func mappingValue(n *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i], n.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+var submatchNameRegexp = regexp.MustCompile(`\(\?P?<([A-Za-z0-9_]+)>`)
+
+// submatchNames returns the names of the named groups in an environment key.
+func submatchNames(key string) map[string]bool {
+	names := make(map[string]bool)
+	for _, m := range submatchNameRegexp.FindAllStringSubmatch(key, -1) {
+		names[m[1]] = true
+	}
+	return names
+}
+
+func isOneOf(s string, options []string) bool {
+	for _, o := range options {
+		if s == o {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumber(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
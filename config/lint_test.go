@@ -0,0 +1,211 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const lintYaml = `metod: deep
+root: "$UNSET/dir"
+packages:
+  used:
+    "": [a]
+  unused:
+    "(bad": [b]
+dots:
+  good:
+    method: copy
+    rules:
+      "distro:(?P<distro>.+) (.*)":
+        a: "$HOME/${distro}/$2"
+    packages:
+      used: "Used"
+  bad:
+    method: links
+    dot_prefix: false
+    environments:
+      "a[": {}
+    deploy:
+      "": [["echo", "$$ESCAPED", "$UNSET"]]
+validate:
+  "": ["fine", "(not"]
+`
+
+func TestLint(t *testing.T) {
+	lookupEnv := func(k string) bool {
+		return k == "HOME"
+	}
+
+	problems, err := Lint(
+		[]byte(lintYaml),
+		[]string{"good", "undotted"},
+		lookupEnv,
+	)
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	expected := []Problem{
+		{"", 1, 1, `Unknown key "metod", did you mean "method"?`},
+		{"", 2, 7, "Environment variable UNSET is not set"},
+		{"", 6, 3, `Package "unused" is not used by any dot`},
+		{"", 7, 5, `Invalid environment key "(bad"`},
+		{"", 8, 1, `Directory "undotted" has no dot in the config`},
+		{"", 16, 3, `Dot "bad" has no directory`},
+		{
+			"",
+			17,
+			13,
+			`Invalid method "links", must be one of deep, shallow, copy, hardlink, none`,
+		},
+		{"", 18, 5, `Unknown key "dot_prefix", did you mean "dot-prefix"?`},
+		{"", 20, 7, `Invalid environment key "a["`},
+		{"", 22, 34, "Environment variable UNSET is not set"},
+		{"", 24, 16, `Invalid environment string "(not"`},
+	}
+
+	if !reflect.DeepEqual(expected, problems) {
+		t.Errorf("expected %#v, got %#v", expected, problems)
+	}
+}
+
+func TestLintRuleOptions(t *testing.T) {
+	in := `dots:
+  dot:
+    rules:
+      "":
+        a: "~/a"
+        b: {target: "~/b", method: copy, mode: "0600", dot-prefix: false}
+        c: {target: "~/c", method: links, mode: "0999", dotprefix: false}
+        d: ["~/d", {target: "~/e", method: hardly}]
+`
+	problems, err := Lint([]byte(in), []string{"dot"}, nil)
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	expected := []Problem{
+		{
+			"",
+			7,
+			36,
+			`Invalid method "links", must be one of deep, shallow, copy, hardlink, none`,
+		},
+		{"", 7, 49, `Invalid mode "0999", must be an octal number`},
+		{"", 7, 57, `Unknown key "dotprefix", did you mean "dot-prefix"?`},
+		{
+			"",
+			8,
+			44,
+			`Invalid method "hardly", must be one of deep, shallow, copy, hardlink, none`,
+		},
+	}
+	if !reflect.DeepEqual(expected, problems) {
+		t.Errorf("expected %#v, got %#v", expected, problems)
+	}
+}
+
+func TestLintLinkModes(t *testing.T) {
+	in := `dots:
+  linked:
+    method: deep
+    mode: "0600"
+    rules:
+      "":
+        a: {target: "~/a", mode: "0644"}
+        b: {target: "~/b", method: shallow, mode: "0644"}
+  copied:
+    method: deep
+    mode: "0600"
+    rules:
+      "":
+        c: {target: "~/c", method: copy}
+`
+	problems, err := Lint([]byte(in), []string{"linked", "copied"}, nil)
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	expected := []Problem{
+		{"", 4, 11, `Mode "0600" is not applied to links`},
+		{"", 7, 34, `Mode "0644" is not applied to links`},
+		{"", 8, 51, `Mode "0644" is not applied to links`},
+	}
+	if !reflect.DeepEqual(expected, problems) {
+		t.Errorf("expected %#v, got %#v", expected, problems)
+	}
+}
+
+func TestLintLinkStyle(t *testing.T) {
+	in := `link-style: relative
+environments:
+  work:
+    link-style: sideways
+`
+	problems, err := Lint([]byte(in), nil, nil)
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	expected := []Problem{
+		{
+			"",
+			4,
+			17,
+			`Invalid link style "sideways", must be one of absolute, relative`,
+		},
+	}
+	if !reflect.DeepEqual(expected, problems) {
+		t.Errorf("expected %#v, got %#v", expected, problems)
+	}
+}
+
+func TestLintBadYaml(t *testing.T) {
+	_, err := Lint([]byte("]"), nil, nil)
+	if err == nil {
+		t.Fatal("expected err to be non-nil, was nil")
+	}
+}
+
+func TestLintDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"estragon.yaml": `dots:
+  a: {}
+packages:
+  used: {"": [a]}
+`,
+		"estragon.d/more.yaml": `dots:
+  a: {}
+include: [other.yaml]
+`,
+		"b/estragon.yaml": `metod: copy
+packages:
+  used: "Used"
+`,
+		"a/file": "",
+	})
+
+	problems, err := LintDir(dir, func(string) bool { return false })
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	more := filepath.Join("estragon.d", "more.yaml")
+	fragment := filepath.Join("b", "estragon.yaml")
+	expected := []Problem{
+		{
+			more,
+			1,
+			1,
+			`dot "a" is defined in both estragon.yaml and ` + more,
+		},
+		{more, 3, 1, "include can only be used in estragon.yaml"},
+		{fragment, 1, 1, `Unknown key "metod", did you mean "method"?`},
+	}
+
+	if !reflect.DeepEqual(expected, problems) {
+		t.Errorf("expected %#v, got %#v", expected, problems)
+	}
+}
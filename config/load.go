@@ -0,0 +1,339 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A configFile is one of the files that make up a config. If dot is not empty,
+// the file is a fragment that configures only that dot.
+type configFile struct {
+	path string
+	dot  string
+	in   []byte
+}
+
+// LoadConfig creates a config from the estragon.yaml file in `dir` and the
+// files that it is split across, with the Environment `s`.
+//
+// The files are merged in this order: estragon.yaml, the files matching each
+// of the `include` globs in the order they are listed, the files matching
+// estragon.d/*.yaml, and finally the estragon.yaml files within the source
+// directory of each dot, which configure just that dot. If two files define
+// the same dot, package alias, environment key, or global setting, or the dots
+// that extend other dots can't be resolved, a non-nil error is returned.
+func LoadConfig(dir string, s EnvSelector) (c Config, err error) {
+	files, err := configFiles(dir)
+	if err != nil {
+		return
+	}
+
+	m := schemaMerger{origins: make(map[string]string)}
+	for i, f := range files {
+		var part schema
+		if f.dot != "" {
+			var d dot
+			err = yaml.Unmarshal(f.in, &d)
+			part.Dots = map[string]dot{f.dot: d}
+		} else {
+			err = yaml.Unmarshal(f.in, &part)
+		}
+
+		rel := relPath(dir, f.path)
+		if err != nil {
+			return c, fmt.Errorf("%s: %w", rel, err)
+		} else if i != 0 && part.Include != nil {
+			return c, fmt.Errorf(
+				"%s: include can only be used in estragon.yaml",
+				rel,
+			)
+		}
+
+		err = m.merge(part, rel)
+		if err != nil {
+			return
+		}
+	}
+
+	err = m.schema.resolveExtends()
+	c.schema = m.schema
+	c.selector = s
+	return
+}
+
+// configFiles finds and reads every file that makes up the config in `dir`, in
+// the order that they are merged.
+func configFiles(dir string) ([]configFile, error) {
+	mainFile := filepath.Join(dir, "estragon.yaml")
+	in, err := os.ReadFile(mainFile)
+	if err != nil {
+		return nil, err
+	}
+	files := []configFile{{path: mainFile, in: in}}
+	seen := map[string]bool{mainFile: true}
+
+	var top struct {
+		Include []string
+	}
+	err = yaml.Unmarshal(in, &top)
+	if err != nil {
+		return nil, fmt.Errorf("estragon.yaml: %w", err)
+	}
+
+	patterns := append(top.Include, filepath.Join("estragon.d", "*.yaml"))
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("Bad include pattern %q: %w", pattern, err)
+		}
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+
+			in, err := os.ReadFile(match)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, configFile{path: match, in: in})
+		}
+	}
+
+	fragments, err := fragmentFiles(dir, files)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, fragments...), nil
+}
+
+// fragmentFiles finds and reads the estragon.yaml files in the source
+// directories of the dots, which are the dots defined by the `files` and the
+// directories of the dots directory. Each configures the dot it is the source
+// of, or the dot named after its directory if several dots share it.
+func fragmentFiles(dir string, files []configFile) ([]configFile, error) {
+	// The files are only read for where the dots are, so that errors in
+	// them are left to be reported by the full parse.
+	var known schema
+	for _, f := range files {
+		var part schema
+		if yaml.Unmarshal(f.in, &part) != nil {
+			continue
+		}
+		if known.DotsDir == "" {
+			known.DotsDir = part.DotsDir
+		}
+		for name, d := range part.Dots {
+			if known.Dots == nil {
+				known.Dots = make(map[string]dot)
+			}
+			if _, ok := known.Dots[name]; !ok {
+				known.Dots[name] = d
+			}
+		}
+	}
+
+	dotsDir := filepath.Join(dir, filepath.FromSlash(known.DotsDir))
+	entries, err := os.ReadDir(dotsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	dots := make([]string, 0, len(entries)+len(known.Dots))
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") ||
+			strings.Contains(e.Name(), "@") || e.Name() == "estragon.d" {
+			// Overlay directories don't configure dots.
+			continue
+		}
+		dots = append(dots, e.Name())
+	}
+	defined := mapKeys(known.Dots)
+	sort.Strings(defined)
+	dots = append(dots, defined...)
+
+	fragments := make([]configFile, 0)
+	seen := make(map[string]bool)
+	for _, name := range dots {
+		source := filepath.FromSlash(known.source(name))
+		fragment := filepath.Join(dir, source, "estragon.yaml")
+		if seen[fragment] {
+			continue
+		}
+		seen[fragment] = true
+
+		in, err := os.ReadFile(fragment)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, configFile{fragment, name, in})
+	}
+
+	return fragments, nil
+}
+
+// FragmentDirs returns the directories whose estragon.yaml files are config
+// fragments of the config in `dir`, as they are found by LoadConfig.
+func FragmentDirs(dir string) ([]string, error) {
+	files, err := configFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make([]string, 0)
+	for _, f := range files {
+		if f.dot != "" {
+			dirs = append(dirs, filepath.Dir(f.path))
+		}
+	}
+	return dirs, nil
+}
+
+func relPath(dir, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
+
+// A schemaMerger merges the schemas of multiple files into one, keeping track
+// of which file defined each part so that conflicts can be reported.
+type schemaMerger struct {
+	schema  schema
+	origins map[string]string
+}
+
+func (m *schemaMerger) merge(s schema, file string) (err error) {
+	dst, src := &m.schema.Common, s.Common
+	err = mergeSetting(m, &dst.Method, src.Method, "method", file)
+	if err != nil {
+		return
+	}
+	err = mergeSetting(m, &dst.Root, src.Root, "root", file)
+	if err != nil {
+		return
+	}
+	err = mergeSetting(m, &dst.DotPrefix, src.DotPrefix, "dot-prefix", file)
+	if err != nil {
+		return
+	}
+	err = mergeSetting(m, &dst.LinkStyle, src.LinkStyle, "link-style", file)
+	if err != nil {
+		return
+	}
+	err = mergeSetting(m, &dst.Fold, src.Fold, "fold", file)
+	if err != nil {
+		return
+	}
+	err = mergeSetting(m, &m.schema.Merge, s.Merge, "merge", file)
+	if err != nil {
+		return
+	}
+	err = mergeSetting(m, &m.schema.DotsDir, s.DotsDir, "dots-dir", file)
+	if err != nil {
+		return
+	}
+	m.schema.Include = append(m.schema.Include, s.Include...)
+	m.schema.Ignore = append(m.schema.Ignore, s.Ignore...)
+
+	err = mergeMap(m, &m.schema.CheckCmd, s.CheckCmd, "check-cmd key", file)
+	if err != nil {
+		return
+	}
+	err = mergeMap(
+		m,
+		&m.schema.InstallCmd,
+		s.InstallCmd,
+		"install-cmd key",
+		file,
+	)
+	if err != nil {
+		return
+	}
+	err = mergeMap(m, &m.schema.Validate, s.Validate, "validate key", file)
+	if err != nil {
+		return
+	}
+	err = mergeMap(
+		m,
+		&m.schema.Environments,
+		s.Environments,
+		"environments key",
+		file,
+	)
+	if err != nil {
+		return
+	}
+	err = mergeMap(m, &m.schema.Packages, s.Packages, "package alias", file)
+	if err != nil {
+		return
+	}
+	err = mergeMap(m, &m.schema.Profiles, s.Profiles, "profile", file)
+	if err != nil {
+		return
+	}
+	return mergeMap(m, &m.schema.Dots, s.Dots, "dot", file)
+}
+
+// record records that `file` defines `what`, returning an error if another
+// file already did.
+func (m *schemaMerger) record(what, file string) error {
+	if prev, ok := m.origins[what]; ok {
+		return fmt.Errorf("%s is defined in both %s and %s", what, prev, file)
+	}
+	m.origins[what] = file
+	return nil
+}
+
+// mergeSetting sets `dst` to `src` if `src` is not the zero value, returning
+// an error if another file already set it.
+func mergeSetting[T comparable](
+	m *schemaMerger,
+	dst *T,
+	src T,
+	what string,
+	file string,
+) error {
+	var zero T
+	if src == zero {
+		return nil
+	}
+	err := m.record(what, file)
+	if err != nil {
+		return err
+	}
+	*dst = src
+	return nil
+}
+
+// mergeMap adds the keys of `src` to `dst`, returning an error if a key was
+// already defined by another file.
+func mergeMap[V any](
+	m *schemaMerger,
+	dst *map[string]V,
+	src map[string]V,
+	what string,
+	file string,
+) error {
+	if len(src) > 0 && *dst == nil {
+		*dst = make(map[string]V)
+	}
+	for _, k := range mapKeys(src) {
+		err := m.record(fmt.Sprintf("%s %q", what, k), file)
+		if err != nil {
+			return err
+		}
+		(*dst)[k] = src[k]
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes each of the files to `dir`, creating the directories they
+// are in.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"estragon.yaml": `
+method: deep
+include: ["conf/*.yaml"]
+ignore: ["*.orig"]
+dots:
+  main: {}
+`,
+		"conf/packages.yaml": `
+packages:
+  foo:
+    test: ["bar"]
+`,
+		"estragon.d/dots.yaml": `
+ignore: ["*.swp"]
+dots:
+  included:
+    root: "/included/"
+`,
+		"fragment/estragon.yaml": `
+root: "/fragment/"
+packages:
+  foo: "From the fragment"
+`,
+		"fragment/file":   "",
+		"nofragment/file": "",
+	})
+
+	c, err := LoadConfig(dir, mockEnvSelector{"test", nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedDots := []string{"fragment", "included", "main"}
+	if dots := c.AllDots(); !reflect.DeepEqual(dots, expectedDots) {
+		t.Errorf("Expected dots %v, got %v", expectedDots, dots)
+	}
+
+	expectedIgnore := []string{"*.orig", "*.swp"}
+	if ignore := c.Ignore("main"); !reflect.DeepEqual(ignore, expectedIgnore) {
+		t.Errorf("Expected ignore %v, got %v", expectedIgnore, ignore)
+	}
+
+	if root := c.DotConfig("included").Root; root != "/included/" {
+		t.Errorf("Expected included root %q, got %q", "/included/", root)
+	}
+
+	fragment := c.DotConfig("fragment")
+	if fragment.Root != "/fragment/" || fragment.Method != "deep" {
+		t.Errorf(
+			"Expected fragment root and method %q and %q, got %q and %q",
+			"/fragment/",
+			"deep",
+			fragment.Root,
+			fragment.Method,
+		)
+	}
+
+	pkgs, err := c.Packages("fragment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPkgs := []Package{{"foo", "From the fragment", []string{"bar"}}}
+	if !reflect.DeepEqual(pkgs, expectedPkgs) {
+		t.Errorf("Expected packages %v, got %v", expectedPkgs, pkgs)
+	}
+}
+
+func TestLoadConfigDotsDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"estragon.yaml":              "include: [dirs.yaml]",
+		"dirs.yaml":                  "dots-dir: dots",
+		"dots/nvim/estragon.yaml":    "method: copy",
+		"ignored/nvim/estragon.yaml": "method: deep",
+	})
+
+	c, err := LoadConfig(dir, mockEnvSelector{"test", nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if method := c.DotConfig("nvim").Method; method != "copy" {
+		t.Errorf("Expected method %q, got %q", "copy", method)
+	}
+	if source := c.Source("nvim"); source != "dots/nvim" {
+		t.Errorf("Expected source %q, got %q", "dots/nvim", source)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		desc  string
+		files map[string]string
+		err   string
+	}{
+		{
+			"Dot defined twice",
+			map[string]string{
+				"estragon.yaml":        "dots: {a: {}}",
+				"estragon.d/more.yaml": "dots: {a: {}}",
+			},
+			`dot "a" is defined in both estragon.yaml and estragon.d/more.yaml`,
+		},
+		{
+			"Dot defined in a fragment and the main file",
+			map[string]string{
+				"estragon.yaml":   "dots: {a: {}}",
+				"a/estragon.yaml": "method: copy",
+			},
+			`dot "a" is defined in both estragon.yaml and a/estragon.yaml`,
+		},
+		{
+			"Dot defined in the main file and a fragment in its source",
+			map[string]string{
+				"estragon.yaml":     "dots: {a: {source: b/a}}",
+				"b/a/estragon.yaml": "method: copy",
+				"b/a/file":          "",
+			},
+			`dot "a" is defined in both estragon.yaml and b/a/estragon.yaml`,
+		},
+		{
+			"Global setting defined twice",
+			map[string]string{
+				"estragon.yaml":        "method: deep",
+				"estragon.d/more.yaml": "method: copy",
+			},
+			"method is defined in both estragon.yaml and estragon.d/more.yaml",
+		},
+		{
+			"Include outside the main file",
+			map[string]string{
+				"estragon.yaml":        "",
+				"estragon.d/more.yaml": "include: [other.yaml]",
+			},
+			"estragon.d/more.yaml: include can only be used in estragon.yaml",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, test.files)
+
+			_, err := LoadConfig(dir, mockEnvSelector{"test", nil})
+			if err == nil {
+				t.Fatalf("Expected error %q, got nil", test.err)
+			}
+			if msg := filepath.ToSlash(err.Error()); !strings.Contains(msg, test.err) {
+				t.Errorf("Expected error %q, got %q", test.err, msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DependencyOrder returns the `dots` along with every dot that they require,
+// directly or through other dots, ordered so that each dot comes after the dots
+// it requires. Otherwise, the dots keep the order they were given in. If dots
+// require each other in a cycle, a non-nil error is returned.
+func (c Config) DependencyOrder(dots []string) ([]string, error) {
+	ordered := make([]string, 0, len(dots))
+	done := make(map[string]bool)
+
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		if done[name] {
+			return nil
+		}
+		for i, n := range chain {
+			if n == name {
+				cycle := append(append([]string{}, chain[i:]...), name)
+				return fmt.Errorf(
+					"Dots require each other: %s",
+					strings.Join(cycle, " -> "),
+				)
+			}
+		}
+
+		chain = append(chain, name)
+		for _, required := range c.schema.Dots[name].Requires {
+			err := visit(required, chain)
+			if err != nil {
+				return err
+			}
+		}
+
+		done[name] = true
+		ordered = append(ordered, name)
+		return nil
+	}
+
+	for _, d := range dots {
+		err := visit(d, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
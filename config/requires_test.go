@@ -0,0 +1,74 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aus-hawk/estragon/env"
+)
+
+const requiresYaml = `
+dots:
+  i3:
+    requires: [x11, fonts]
+  x11:
+    requires: [fonts]
+  fonts: {}
+  polybar:
+    requires: [i3, fonts]
+  loop-a:
+    requires: [loop-b]
+  loop-b:
+    requires: [loop-a]
+`
+
+func TestDependencyOrder(t *testing.T) {
+	c, err := NewConfig([]byte(requiresYaml), env.NewEnvironment(""))
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	tests := []struct {
+		desc     string
+		dots     []string
+		expected []string
+		err      string
+	}{
+		{
+			"Requirements are added first",
+			[]string{"i3"},
+			[]string{"fonts", "x11", "i3"},
+			"",
+		},
+		{
+			"Given order is kept otherwise",
+			[]string{"polybar", "unconfigured", "fonts"},
+			[]string{"fonts", "x11", "i3", "polybar", "unconfigured"},
+			"",
+		},
+		{
+			"Cycle",
+			[]string{"fonts", "loop-a"},
+			nil,
+			"Dots require each other: loop-a -> loop-b -> loop-a",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ordered, err := c.DependencyOrder(test.dots)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("expected err to be nil, was " + err.Error())
+			}
+			if !reflect.DeepEqual(test.expected, ordered) {
+				t.Errorf("expected %v, got %v", test.expected, ordered)
+			}
+		})
+	}
+}
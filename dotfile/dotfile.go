@@ -1,8 +1,12 @@
 package dotfile
 
 import (
+	"fmt"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 )
 
 // PathExpander is a type of function that takes a path and returns an expanded
@@ -10,12 +14,23 @@ import (
 // expanding the path.
 type PathExpander func(string) (string, error)
 
+// An AlternateSelector selects the environment key of the alternate of a file
+// that best matches the environment out of `keys`. If none of them match, ok
+// is false.
+type AlternateSelector func(keys []string) (key string, ok bool)
+
 // Resolver is a type that is used to resolve files to maps from files to their
 // output locations.
 type Resolver struct {
 	dotRoot, outRoot string
 	dotPrefix        bool
 	expand           PathExpander
+	sources          map[string]string
+	selectAlternate  AlternateSelector
+	alternates       map[string]string
+	ignorer          Ignorer
+	dotPrefixes      map[string]bool
+	shallowRules     map[string]bool
 }
 
 // NewResolver creates a new Resolver. dotRoot is the root of all of the files
@@ -28,78 +43,285 @@ func NewResolver(
 	dotPrefix bool,
 	expand PathExpander,
 ) Resolver {
-	return Resolver{dotRoot, outRoot, dotPrefix, expand}
+	return Resolver{
+		dotRoot:   dotRoot,
+		outRoot:   outRoot,
+		dotPrefix: dotPrefix,
+		expand:    expand,
+	}
+}
+
+// WithSources returns a copy of the Resolver where the files that are keys of
+// `sources` are found at their values instead of within the root of the dot.
+// This is used for files that come from somewhere other than the dot root,
+// such as an overlay directory.
+func (r Resolver) WithSources(sources map[string]string) Resolver {
+	r.sources = sources
+	return r
+}
+
+// WithAlternates returns a copy of the Resolver that picks between the
+// alternates of files using `selectAlternate`. An alternate of a file is named
+// after it followed by ## and a comma separated list of the fields of an
+// environment key, like gitconfig##work or dot-bashrc##distro:arch,laptop. The
+// best matching alternate is resolved as if it were the file, falling back to
+// the file itself, and the alternates that aren't picked are ignored.
+func (r Resolver) WithAlternates(selectAlternate AlternateSelector) Resolver {
+	r.selectAlternate = selectAlternate
+	return r
+}
+
+// WithIgnorer returns a copy of the Resolver where the files that `ignorer`
+// ignores are left out before they are resolved.
+func (r Resolver) WithIgnorer(ignorer Ignorer) Resolver {
+	r.ignorer = ignorer
+	return r
+}
+
+// A Placement is where a file is deployed, along with the key of the rule
+// that placed it, which is empty if no rule did. File is the name of the file
+// within the dot, which for a picked alternate is the name it is deployed as.
+type Placement struct {
+	Target string
+	Rule   string
+	File   string
+}
+
+// WithDotPrefixes returns a copy of the Resolver where the rules that are keys
+// of `dotPrefixes` expand "dot-" prefixes in the files they place according to
+// their value instead of the setting of the Resolver.
+func (r Resolver) WithDotPrefixes(dotPrefixes map[string]bool) Resolver {
+	r.dotPrefixes = dotPrefixes
+	return r
+}
+
+// WithShallowRules returns a copy of the Resolver where the rules for
+// directories that are keys of `shallow` place the whole directory if their
+// value is true, and every file within it if it is false, no matter which of
+// DeepResolve and ShallowResolve is used.
+func (r Resolver) WithShallowRules(shallow map[string]bool) Resolver {
+	r.shallowRules = shallow
+	return r
 }
 
 // DeepResolve resolves the placement of files by mapping every input file to an
 // output file and using the rules to change the location of individual files
 // mentioned or all files within a mentioned folder, the more specific the
 // folder the higher priority to take when resolving.
+//
+// Rule keys can also be patterns that place every matching file in the
+// directory they map to. A rule for the exact path of a file takes precedence
+// over a pattern, and a pattern takes precedence over a rule for a directory
+// containing the file.
 func (r Resolver) DeepResolve(
 	files []string,
 	rules map[string]string,
 ) (map[string]string, error) {
+	placements, err := r.DeepPlace(files, rules)
+	return targets(placements), err
+}
+
+// DeepPlace is like DeepResolve, except that it also returns the rule that
+// placed each file.
+func (r Resolver) DeepPlace(
+	files []string,
+	rules map[string]string,
+) (map[string]Placement, error) {
+	files = r.ignorer.unignored(files)
+	files, r.alternates = r.pickAlternates(files)
+	patterns, err := compilePatterns(rules)
+	if err != nil {
+		return nil, err
+	}
+
 	// Ignore ruleless if an empty key exists in the rules map.
 	_, ignoreRuleless := rules[""]
-	fileMap := make(map[string]string)
+	placements := make(map[string]Placement)
 
 	for _, file := range files {
-		outFile, ok := rules[file]
+		key, ok := matchRule(file, rules, patterns)
 		if !ok {
-			// Check if the file is in a subdirectory with a rule.
-			outFile, ok = resolveSubdirRule(file, rules, r.dotPrefix)
+			if !ignoreRuleless {
+				placements[file] = Placement{}
+			}
+			continue
 		}
 
-		if ok {
-			if outFile != "" {
-				fileMap[file] = outFile
+		if r.shallowRules[key] && key != file && !isPattern(key) {
+			// The whole directory is placed instead of its files.
+			if rules[key] != "" {
+				placements[key] = Placement{Target: rules[key], Rule: key}
 			}
-		} else if !ignoreRuleless {
-			fileMap[file] = ""
+		} else if outFile := r.ruleTarget(file, key, rules); outFile != "" {
+			placements[file] = Placement{Target: outFile, Rule: key}
 		}
 	}
 
-	return r.expandResolvedPaths(fileMap)
+	return r.expandPlacements(placements)
 }
 
 // ShallowResolve resolves the placement of files by creating a map from only
 // files that exist and are in the rules to their associated location in the
 // rules. Dot expansion is always ignored. If the rule map has no key-value
 // pairs that match any of the files, the returned map has a single key from the
-// root of the dots to the output root. If something goes wrong during file
-// expansion, that is reflected in a non-nil error.
+// root of the dots to the output root. A directory that would be linked while
+// containing ignored files is linked file by file instead, as shallowly as
+// possible. If something goes wrong during file expansion or a pattern is
+// invalid, that is reflected in a non-nil error.
 func (r Resolver) ShallowResolve(
 	files []string,
 	rules map[string]string,
 ) (map[string]string, error) {
+	placements, err := r.ShallowPlace(files, rules)
+	return targets(placements), err
+}
+
+// ShallowPlace is like ShallowResolve, except that it also returns the rule
+// that placed each file.
+func (r Resolver) ShallowPlace(
+	files []string,
+	rules map[string]string,
+) (map[string]Placement, error) {
 	r.dotPrefix = false
+	ignored := make([]string, 0)
+	for _, file := range files {
+		if r.ignorer.Ignored(file) {
+			ignored = append(ignored, file)
+		}
+	}
+	files = r.ignorer.unignored(files)
+	files, r.alternates = r.pickAlternates(files)
+	patterns, err := compilePatterns(rules)
+	if err != nil {
+		return nil, err
+	}
 
-	fileMap := make(map[string]string)
+	placements := make(map[string]Placement)
 	for _, file := range files {
-		outFile, ok := rules[file]
+		key, ok := matchRule(file, rules, patterns)
 		if !ok {
-			file, _, ok = splitSubdirRule(file, rules)
-			outFile = rules[file]
+			continue
 		}
 
-		if ok && outFile != "" {
-			fileMap[file] = outFile
+		shallow, set := r.shallowRules[key]
+		if key == file || isPattern(key) || (set && !shallow) {
+			// The file is placed by itself.
+			if outFile := r.ruleTarget(file, key, rules); outFile != "" {
+				placements[file] = Placement{Target: outFile, Rule: key}
+			}
+		} else if rules[key] != "" {
+			placements[key] = Placement{Target: rules[key], Rule: key}
 		}
 	}
 
-	if len(fileMap) == 0 {
-		fileMap[""] = ""
+	if len(placements) == 0 {
+		placements[""] = Placement{}
 	}
 
-	return r.expandResolvedPaths(fileMap)
+	// A linked directory would show the ignored files, the names of
+	// alternates, and the files that an overlay replaces as they are in the
+	// dot, so the directories with any of them are unfolded.
+	unfold := ignored
+	for name := range r.alternates {
+		unfold = append(unfold, name)
+	}
+	for _, file := range files {
+		if _, ok := r.sources[file]; ok {
+			unfold = append(unfold, file)
+		}
+	}
+
+	if len(unfold) > 0 {
+		unfolded := make(map[string]Placement)
+		for file, p := range placements {
+			unfoldIgnored(file, p, files, unfold, unfolded)
+		}
+		placements = unfolded
+	}
+
+	return r.expandPlacements(placements)
 }
 
-func (r Resolver) expandResolvedPaths(
-	fileMap map[string]string,
-) (map[string]string, error) {
-	expandedFileMap := make(map[string]string)
+// unfoldIgnored adds `file` placed at `p` to `placements` if it doesn't contain
+// any of the `ignored` files. Otherwise, each entry directly within it is added
+// the same way, placed at the same name within the target of `p`, so that only
+// the entries without ignored files are linked. `files` are the files that
+// aren't ignored, which are added even if they are in `ignored` themselves.
+func unfoldIgnored(
+	file string,
+	p Placement,
+	files, ignored []string,
+	placements map[string]Placement,
+) {
+	for _, f := range files {
+		if f == file {
+			placements[file] = p
+			return
+		}
+	}
+
+	within := func(f string) bool {
+		return file == "" || f == file || strings.HasPrefix(f, file+"/")
+	}
+
+	containsIgnored := false
+	for _, f := range ignored {
+		if within(f) {
+			containsIgnored = true
+			break
+		}
+	}
+	if !containsIgnored {
+		placements[file] = p
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if !within(f) || f == file {
+			continue
+		}
+
+		rel := strings.TrimPrefix(f, file+"/")
+		if file == "" {
+			rel = f
+		}
+		name, _, _ := strings.Cut(rel, "/")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		child := Placement{Rule: p.Rule}
+		if p.Target != "" {
+			child.Target = filepath.Join(p.Target, name)
+		}
+		unfoldIgnored(path.Join(file, name), child, files, ignored, placements)
+	}
+}
+
+// targets returns the map from each file in `placements` to its target.
+func targets(placements map[string]Placement) map[string]string {
+	if placements == nil {
+		return nil
+	}
+	t := make(map[string]string, len(placements))
+	for file, p := range placements {
+		t[file] = p.Target
+	}
+	return t
+}
+
+// expandPlacements finds where each file in `placements` is and expands the
+// paths of the files and their targets, placing the files without a target
+// relative to the output root.
+func (r Resolver) expandPlacements(
+	placements map[string]Placement,
+) (map[string]Placement, error) {
+	expanded := make(map[string]Placement)
 
-	for file, outFile := range fileMap {
+	for file, p := range placements {
+		outFile := p.Target
 		if outFile == "" {
 			// Empty output files default to the root.
 			outBaseFile := file
@@ -108,7 +330,15 @@ func (r Resolver) expandResolvedPaths(
 			}
 			outFile = filepath.Join(r.outRoot, outBaseFile)
 		}
-		file = filepath.Join(r.dotRoot, file)
+		name := file
+		if alt, ok := r.alternates[file]; ok {
+			file = alt
+		}
+		if src, ok := r.sources[file]; ok {
+			file = src
+		} else {
+			file = filepath.Join(r.dotRoot, file)
+		}
 
 		var err error
 		file, err = r.expand(file)
@@ -121,44 +351,220 @@ func (r Resolver) expandResolvedPaths(
 			return nil, err
 		}
 
-		expandedFileMap[file] = outFile
+		expanded[file] = Placement{outFile, p.Rule, name}
 	}
 
-	return expandedFileMap, nil
+	return expanded, nil
 }
 
-// resolveSubdirRule will take a file and find a subdirectory that is specified
-// in the rules and use that rule to resolve the rule of the file. The bool it
-// returns indicates if the resolution was successful.
+// pickAlternates replaces the alternates of each file in `files` with the file
+// they are an alternate of, returning the new files and a map from each file
+// with a picked alternate to that alternate. Without an AlternateSelector,
+// alternates are treated like any other file.
+func (r Resolver) pickAlternates(
+	files []string,
+) ([]string, map[string]string) {
+	if r.selectAlternate == nil || files == nil {
+		return files, nil
+	}
+
+	picked := make([]string, 0, len(files))
+	alternates := make(map[string]map[string]string)
+	for _, file := range files {
+		name, fields, ok := strings.Cut(file, altSeparator)
+		if !ok || strings.Contains(fields, "/") {
+			picked = append(picked, file)
+			continue
+		}
+
+		key := strings.ReplaceAll(fields, ",", " ")
+		if alternates[name] == nil {
+			alternates[name] = make(map[string]string)
+		}
+		alternates[name][key] = file
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range picked {
+		seen[file] = true
+	}
+	chosen := make(map[string]string)
+	for name, alts := range alternates {
+		keys := make([]string, 0, len(alts))
+		for key := range alts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		key, ok := r.selectAlternate(keys)
+		if !ok {
+			continue
+		}
+		chosen[name] = alts[key]
+		if !seen[name] {
+			picked = append(picked, name)
+		}
+	}
+
+	return picked, chosen
+}
+
+// altSeparator separates the name of a file from the environment key of an
+// alternate of it.
+const altSeparator = "##"
+
+// matchRule returns the key of the rule in `rules` that places `file`. A rule
+// for the file itself takes precedence over the first of the `patterns` that
+// matches it, which takes precedence over the rule for the closest directory
+// containing it. The bool it returns indicates if any rule matched.
+func matchRule(
+	file string,
+	rules map[string]string,
+	patterns []rulePattern,
+) (string, bool) {
+	if _, ok := rules[file]; ok {
+		return file, true
+	}
+	for _, p := range patterns {
+		if p.regexp.MatchString(file) {
+			return p.key, true
+		}
+	}
+	dir, _, ok := splitSubdirRule(file, rules)
+	return dir, ok
+}
+
+// ruleTarget returns where the rule with the key `key` places `file`.
 //
-// If the file is `a/b.txt`, and there is a rule from `a` to `c`, then the
-// output file will be `c/b.txt`.
+// A rule for the file itself places it at its value. A pattern places it in
+// the directory that is its value under its base name. A rule for a directory
+// containing it replaces the directory with its value, so if the file is
+// `a/b.txt`, and there is a rule from `a` to `c`, then the output file will be
+// `c/b.txt`.
 //
-// If dot is true, dot prefixes are expanded on the ruleless part of the path.
-// So `a/dot-b/dot-d` turns into `c/.b/.d` using the last example's rules.
+// If dot prefixes are expanded for the rule, they are expanded on the part of
+// the path that the rule doesn't replace. So `a/dot-b/dot-d` turns into
+// `c/.b/.d` using the last example's rules.
 //
-// If the closest parent directory with a rule maps to an empty string, an empty
-// string is returned to indicate an ignored path.
-func resolveSubdirRule(
-	file string,
+// If the rule maps to an empty string, an empty string is returned to indicate
+// an ignored path.
+func (r Resolver) ruleTarget(
+	file, key string,
 	rules map[string]string,
-	dotPrefix bool,
-) (string, bool) {
-	outDir, subpath, ok := splitSubdirRule(file, rules)
+) string {
+	target := rules[key]
+	if target == "" || key == file {
+		return target
+	}
+
+	subpath := strings.TrimPrefix(file, key+"/")
+	if isPattern(key) {
+		subpath = filepath.Base(file)
+	}
+	dotPrefix, ok := r.dotPrefixes[key]
+	if !ok {
+		dotPrefix = r.dotPrefix
+	}
+	if dotPrefix {
+		subpath = expandDotPrefixes(subpath)
+	}
+	return filepath.Join(target, subpath)
+}
+
+// A rulePattern is a rule key that matches files by a glob or a regular
+// expression instead of by their path.
+type rulePattern struct {
+	key    string
+	regexp *regexp.Regexp
+}
+
+// isPattern returns if a rule key is a pattern instead of a path. Keys
+// surrounded by slashes are regular expressions, and keys containing *, ?, or
+// [ are globs.
+func isPattern(key string) bool {
+	return isRegexpKey(key) || strings.ContainsAny(key, "*?[")
+}
 
-	if ok {
-		outDir = rules[outDir]
-		if outDir == "" {
-			return "", true
+func isRegexpKey(key string) bool {
+	return len(key) > 2 &&
+		strings.HasPrefix(key, "/") &&
+		strings.HasSuffix(key, "/")
+}
+
+// compilePatterns compiles the keys of the rules that are patterns, ordered
+// from the longest key to the shortest, which is the order they take
+// precedence in. If a pattern is invalid, a non-nil error is returned.
+func compilePatterns(rules map[string]string) ([]rulePattern, error) {
+	patterns := make([]rulePattern, 0)
+	for key := range rules {
+		if !isPattern(key) {
+			continue
+		}
+
+		var expr string
+		if isRegexpKey(key) {
+			expr = key[1 : len(key)-1]
 		} else {
-			if dotPrefix {
-				subpath = expandDotPrefixes(subpath)
+			expr = globRegexp(key, !strings.Contains(key, "/"))
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid rule pattern %q: %w", key, err)
+		}
+		patterns = append(patterns, rulePattern{key, re})
+	}
+
+	sort.Slice(patterns, func(i, j int) bool {
+		ki, kj := patterns[i].key, patterns[j].key
+		if len(ki) != len(kj) {
+			return len(ki) > len(kj)
+		}
+		return ki < kj
+	})
+	return patterns, nil
+}
+
+// globRegexp converts a glob to a regular expression matching the same paths.
+// A * matches anything but a slash, a ** matches anything, and a **/ matches
+// any number of directories. If `anywhere` is true, the glob matches the end
+// of a path in any directory instead of the whole path.
+func globRegexp(glob string, anywhere bool) string {
+	var b strings.Builder
+	b.WriteString("^")
+	if anywhere {
+		b.WriteString("(.*/)?")
+	}
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(glob[i:]))
+				i = len(glob)
+			} else {
+				class := glob[i : i+end+1]
+				class = strings.Replace(class, "[!", "[^", 1)
+				b.WriteString(class)
+				i += end
 			}
-			return filepath.Join(outDir, subpath), true
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
 		}
-	} else {
-		return "", false
 	}
+
+	b.WriteString("$")
+	return b.String()
 }
 
 // splitSubdirRule splits the parts of the filepath that has a rule and the part
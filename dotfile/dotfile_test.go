@@ -4,11 +4,12 @@ import (
 	"errors"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 func TestNewResolver(t *testing.T) {
-	expected := Resolver{"dotRoot", "outRoot", true, nil}
+	expected := Resolver{dotRoot: "dotRoot", outRoot: "outRoot", dotPrefix: true}
 	actual := NewResolver("dotRoot", "outRoot", true, nil)
 	if !reflect.DeepEqual(expected, actual) {
 		t.Errorf("expected %#v, got %#v", expected, actual)
@@ -138,6 +139,62 @@ func TestDeepResolve(t *testing.T) {
 			map[string]string{dr("not/ignored"): "~/here"},
 			false,
 		},
+		{
+			"Pattern rules",
+			true,
+			goodExpand,
+			[]string{
+				"a.sh",
+				"bin/b.sh",
+				"bin/dot-c",
+				"themes/dark/x.conf",
+				"themes/y.conf",
+				"themes/z.txt",
+				"notes.md",
+			},
+			map[string]string{
+				"*.sh":              "~/.local/bin",
+				"themes/**/*.conf":  "~/.config/themes",
+				"/^bin/dot-[a-z]$/": "/bin/dots",
+				"/\\.md$/":          "",
+			},
+			map[string]string{
+				dr("a.sh"):               "~/.local/bin/a.sh",
+				dr("bin/b.sh"):           "~/.local/bin/b.sh",
+				dr("bin/dot-c"):          "/bin/dots/.c",
+				dr("themes/dark/x.conf"): "~/.config/themes/x.conf",
+				dr("themes/y.conf"):      "~/.config/themes/y.conf",
+				dr("themes/z.txt"):       or("themes/z.txt"),
+			},
+			false,
+		},
+		{
+			"Exact keys take precedence over patterns over directories",
+			true,
+			goodExpand,
+			[]string{"bin/a.sh", "bin/b.sh", "bin/c"},
+			map[string]string{
+				"bin/a.sh": "~/a.sh",
+				"*.sh":     "~/.local/bin",
+				"bin/*.sh": "~/scripts",
+				"bin":      "~/bin",
+			},
+			map[string]string{
+				dr("bin/a.sh"): "~/a.sh",
+				dr("bin/b.sh"): "~/scripts/b.sh",
+				dr("bin/c"):    "~/bin/c",
+			},
+			false,
+		},
+		{
+			"Invalid pattern",
+			false,
+			goodExpand,
+			[]string{"a"},
+			map[string]string{"/(/": "~"},
+			nil,
+			true,
+		},
 		{
 			"Bad expansion",
 			false,
@@ -232,6 +289,18 @@ func TestShallowResolve(t *testing.T) {
 			map[string]string{dr("not/ignored"): "~/f.txt"},
 			false,
 		},
+		{
+			"Pattern rules",
+			false,
+			goodExpand,
+			[]string{"a.sh", "bin/b.sh", "c"},
+			map[string]string{"*.sh": "~/bin"},
+			map[string]string{
+				dr("a.sh"):     "~/bin/a.sh",
+				dr("bin/b.sh"): "~/bin/b.sh",
+			},
+			false,
+		},
 		{
 			"Bad expand",
 			true,
@@ -270,3 +339,262 @@ func TestShallowResolve(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveWithSources(t *testing.T) {
+	resolver := NewResolver("/dot/root", "/out/root", true, goodExpand)
+	resolver = resolver.WithSources(map[string]string{
+		"dot-a": "/dot/overlay/dot-a",
+	})
+
+	resolved, err := resolver.DeepResolve([]string{"dot-a", "b"}, nil)
+	if err != nil {
+		t.Fatal("Expected nil err")
+	}
+
+	expected := map[string]string{
+		"/dot/overlay/dot-a": "/out/root/.a",
+		"/dot/root/b":        "/out/root/b",
+	}
+	if !reflect.DeepEqual(expected, resolved) {
+		t.Errorf("Expected %#v, got %#v", expected, resolved)
+	}
+}
+
+// selectWorkArch selects the alternate keys with only "work" and "arch" fields,
+// where keys with more fields are more specific.
+func selectWorkArch(keys []string) (string, bool) {
+	best, ok := "", false
+	for _, key := range keys {
+		matches := true
+		for _, field := range strings.Fields(key) {
+			matches = matches && (field == "work" || field == "arch")
+		}
+		if matches && len(key) > len(best) {
+			best, ok = key, true
+		}
+	}
+	return best, ok
+}
+
+func TestResolveWithAlternates(t *testing.T) {
+	resolver := NewResolver("/dot/root", "/out/root", true, goodExpand)
+	resolver = resolver.WithAlternates(selectWorkArch)
+
+	files := []string{
+		"gitconfig",
+		"gitconfig##work",
+		"gitconfig##home",
+		"dot-bashrc##arch,work",
+		"dot-bashrc##work",
+		"dot-zshrc",
+		"dot-zshrc##home",
+		"only##home",
+	}
+	resolved, err := resolver.DeepResolve(
+		files,
+		map[string]string{"gitconfig": "~/.gitconfig"},
+	)
+	if err != nil {
+		t.Fatal("Expected nil err")
+	}
+
+	expected := map[string]string{
+		"/dot/root/gitconfig##work":       "~/.gitconfig",
+		"/dot/root/dot-bashrc##arch,work": "/out/root/.bashrc",
+		"/dot/root/dot-zshrc":             "/out/root/.zshrc",
+	}
+	if !reflect.DeepEqual(expected, resolved) {
+		t.Errorf("Expected %#v, got %#v", expected, resolved)
+	}
+
+	// Picked alternates are placed under the name of the file.
+	placements, err := resolver.DeepPlace(files, nil)
+	if err != nil {
+		t.Fatal("Expected nil err")
+	}
+	expectedFiles := map[string]string{
+		"/dot/root/gitconfig##work":       "gitconfig",
+		"/dot/root/dot-bashrc##arch,work": "dot-bashrc",
+		"/dot/root/dot-zshrc":             "dot-zshrc",
+	}
+	for file, name := range expectedFiles {
+		if placements[file].File != name {
+			t.Errorf(
+				"Expected %s to be placed as %q, got %q",
+				file,
+				name,
+				placements[file].File,
+			)
+		}
+	}
+}
+
+func TestShallowResolveWithAlternates(t *testing.T) {
+	resolver := NewResolver("/dot/root", "/out/root", true, goodExpand)
+	resolver = resolver.WithAlternates(selectWorkArch).WithSources(
+		map[string]string{"nvim/init.lua": "/overlay/nvim/init.lua"},
+	)
+
+	files := []string{
+		"git/config##work",
+		"git/config##home",
+		"git/ignore",
+		"nvim/init.lua",
+		"nvim/lua/a.lua",
+		"zsh/zshrc",
+	}
+
+	tests := []struct {
+		desc     string
+		rules    map[string]string
+		expected map[string]string
+	}{
+		{
+			"Whole dot",
+			nil,
+			map[string]string{
+				"/dot/root/git/config##work": "/out/root/git/config",
+				"/dot/root/git/ignore":       "/out/root/git/ignore",
+				"/overlay/nvim/init.lua":     "/out/root/nvim/init.lua",
+				"/dot/root/nvim/lua":         "/out/root/nvim/lua",
+				"/dot/root/zsh":              "/out/root/zsh",
+			},
+		},
+		{
+			"Linked directory",
+			map[string]string{"git": "~/.config/git"},
+			map[string]string{
+				"/dot/root/git/config##work": "~/.config/git/config",
+				"/dot/root/git/ignore":       "~/.config/git/ignore",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			resolved, err := resolver.ShallowResolve(files, test.rules)
+			if err != nil {
+				t.Fatal("Expected nil err")
+			}
+			if !reflect.DeepEqual(test.expected, resolved) {
+				t.Errorf("Expected %#v, got %#v", test.expected, resolved)
+			}
+		})
+	}
+}
+
+func TestResolveWithIgnorer(t *testing.T) {
+	ignorer, err := Ignorer{}.With("", []string{"README.md", "*.orig"})
+	if err != nil {
+		t.Fatal("Expected nil err")
+	}
+	resolver := NewResolver("/dot/root", "/out/root", true, goodExpand)
+	resolver = resolver.WithIgnorer(ignorer)
+
+	files := []string{
+		"README.md",
+		".estragonignore",
+		"dot-vimrc",
+		"dot-vim/README.md",
+		"dot-vim/colors/a.vim",
+		"dot-vim/ftplugin/b.vim",
+		"dot-vim/ftplugin/b.vim.orig",
+		"bin/c",
+	}
+
+	t.Run("Deep", func(t *testing.T) {
+		resolved, err := resolver.DeepResolve(files, nil)
+		if err != nil {
+			t.Fatal("Expected nil err")
+		}
+		expected := map[string]string{
+			"/dot/root/dot-vimrc":              "/out/root/.vimrc",
+			"/dot/root/dot-vim/colors/a.vim":   "/out/root/.vim/colors/a.vim",
+			"/dot/root/dot-vim/ftplugin/b.vim": "/out/root/.vim/ftplugin/b.vim",
+			"/dot/root/bin/c":                  "/out/root/bin/c",
+		}
+		if !reflect.DeepEqual(expected, resolved) {
+			t.Errorf("Expected %#v, got %#v", expected, resolved)
+		}
+	})
+
+	t.Run("Shallow", func(t *testing.T) {
+		resolved, err := resolver.ShallowResolve(files, nil)
+		if err != nil {
+			t.Fatal("Expected nil err")
+		}
+		expected := map[string]string{
+			"/dot/root/dot-vimrc":              "/out/root/dot-vimrc",
+			"/dot/root/dot-vim/colors":         "/out/root/dot-vim/colors",
+			"/dot/root/dot-vim/ftplugin/b.vim": "/out/root/dot-vim/ftplugin/b.vim",
+			"/dot/root/bin":                    "/out/root/bin",
+		}
+		if !reflect.DeepEqual(expected, resolved) {
+			t.Errorf("Expected %#v, got %#v", expected, resolved)
+		}
+	})
+
+	t.Run("Shallow with rules", func(t *testing.T) {
+		resolved, err := resolver.ShallowResolve(
+			files,
+			map[string]string{"dot-vim": "~/.vim"},
+		)
+		if err != nil {
+			t.Fatal("Expected nil err")
+		}
+		expected := map[string]string{
+			"/dot/root/dot-vim/colors":         "~/.vim/colors",
+			"/dot/root/dot-vim/ftplugin/b.vim": "~/.vim/ftplugin/b.vim",
+		}
+		if !reflect.DeepEqual(expected, resolved) {
+			t.Errorf("Expected %#v, got %#v", expected, resolved)
+		}
+	})
+}
+
+func TestPlaceWithRuleSettings(t *testing.T) {
+	resolver := NewResolver("/dot/root", "/out/root", true, goodExpand)
+	resolver = resolver.WithDotPrefixes(map[string]bool{
+		"dot-a": false,
+	}).WithShallowRules(map[string]bool{
+		"dot-b": true,
+		"dot-c": false,
+	})
+
+	files := []string{"dot-a/dot-x", "dot-b/y", "dot-b/z", "dot-c/dot-w", "e"}
+	rules := map[string]string{
+		"dot-a": "~/a",
+		"dot-b": "~/b",
+		"dot-c": "~/c",
+	}
+
+	t.Run("Deep", func(t *testing.T) {
+		placements, err := resolver.DeepPlace(files, rules)
+		if err != nil {
+			t.Fatal("Expected nil err")
+		}
+		expected := map[string]Placement{
+			"/dot/root/dot-a/dot-x": {"~/a/dot-x", "dot-a", "dot-a/dot-x"},
+			"/dot/root/dot-b":       {"~/b", "dot-b", "dot-b"},
+			"/dot/root/dot-c/dot-w": {"~/c/.w", "dot-c", "dot-c/dot-w"},
+			"/dot/root/e":           {"/out/root/e", "", "e"},
+		}
+		if !reflect.DeepEqual(expected, placements) {
+			t.Errorf("Expected %#v, got %#v", expected, placements)
+		}
+	})
+
+	t.Run("Shallow", func(t *testing.T) {
+		placements, err := resolver.ShallowPlace(files, rules)
+		if err != nil {
+			t.Fatal("Expected nil err")
+		}
+		expected := map[string]Placement{
+			"/dot/root/dot-a":       {"~/a", "dot-a", "dot-a"},
+			"/dot/root/dot-b":       {"~/b", "dot-b", "dot-b"},
+			"/dot/root/dot-c/dot-w": {"~/c/dot-w", "dot-c", "dot-c/dot-w"},
+		}
+		if !reflect.DeepEqual(expected, placements) {
+			t.Errorf("Expected %#v, got %#v", expected, placements)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package dotfile
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of the files within a dot that list the files to
+// ignore in the directory they are in. They are always ignored themselves.
+const IgnoreFile = ".estragonignore"
+
+// An Ignorer decides which files of a dot are ignored using patterns with the
+// same semantics as a .gitignore file.
+type Ignorer struct {
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	dir     string
+	negate  bool
+	dirOnly bool
+	regexp  *regexp.Regexp
+}
+
+// With returns a copy of the Ignorer that also uses the patterns in `lines`,
+// which are relative to the directory `dir` within the dot. Like in a
+// .gitignore file, blank lines and lines starting with # are skipped, a ! in
+// front of a pattern includes files that earlier patterns ignored, a pattern
+// ending in a slash only matches directories, and a pattern without a slash
+// other than at its end matches files in any directory. If a pattern is
+// invalid, a non-nil error is returned.
+func (ig Ignorer) With(dir string, lines []string) (Ignorer, error) {
+	dir = strings.Trim(path.Clean("/"+dir), "/")
+	patterns := append([]ignorePattern(nil), ig.patterns...)
+
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := ignorePattern{dir: dir}
+		if p.negate = strings.HasPrefix(line, "!"); p.negate {
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// An escaped leading # or !.
+			line = line[1:]
+		}
+		if p.dirOnly = strings.HasSuffix(line, "/"); p.dirOnly {
+			line = strings.TrimRight(line, "/")
+		}
+
+		anywhere := !strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+
+		re, err := regexp.Compile(globRegexp(line, anywhere))
+		if err != nil {
+			return ig, fmt.Errorf("Invalid ignore pattern %q: %w", line, err)
+		}
+		p.regexp = re
+		patterns = append(patterns, p)
+	}
+
+	ig.patterns = patterns
+	return ig, nil
+}
+
+// Ignored returns if the file `file` is ignored, either because a pattern
+// matches it or because a pattern matches a directory containing it.
+func (ig Ignorer) Ignored(file string) bool {
+	if path.Base(file) == IgnoreFile {
+		return true
+	}
+
+	parts := strings.Split(file, "/")
+	for i := range parts {
+		isDir := i < len(parts)-1
+		if ig.matches(strings.Join(parts[:i+1], "/"), isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns if the last pattern that matches the file or directory `p`
+// ignores it.
+func (ig Ignorer) matches(p string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range ig.patterns {
+		rel := p
+		if pattern.dir != "" {
+			var ok bool
+			rel, ok = strings.CutPrefix(p, pattern.dir+"/")
+			if !ok {
+				continue
+			}
+		}
+
+		if pattern.dirOnly && !isDir {
+			continue
+		} else if pattern.regexp.MatchString(rel) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+// unignored returns the files in `files` that the Ignorer doesn't ignore.
+func (ig Ignorer) unignored(files []string) []string {
+	if files == nil {
+		return nil
+	}
+
+	kept := make([]string, 0, len(files))
+	for _, file := range files {
+		if !ig.Ignored(file) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,75 @@
+package dotfile
+
+import "testing"
+
+func TestIgnored(t *testing.T) {
+	ignorer, err := Ignorer{}.With("", []string{
+		"# editor files",
+		"*.swp",
+		"*.orig",
+		"!keep.orig",
+		"/README.md",
+		"build/",
+		"docs/**/*.txt",
+		`\#notes`,
+	})
+	if err != nil {
+		t.Fatal("Expected nil err")
+	}
+	ignorer, err = ignorer.With("nvim", []string{"lazy-lock.json", "/plugin"})
+	if err != nil {
+		t.Fatal("Expected nil err")
+	}
+
+	tests := []struct {
+		file    string
+		ignored bool
+	}{
+		{"a", false},
+		{".a.swp", true},
+		{"dir/.a.swp", true},
+		{"a.orig", true},
+		{"dir/keep.orig", false},
+		{"README.md", true},
+		{"dir/README.md", false},
+		{"build/out", true},
+		{"build", false},
+		{"dir/build/out", true},
+		{"docs/a.txt", true},
+		{"docs/a/b/c.txt", true},
+		{"docs/a.md", false},
+		{"#notes", true},
+		{"nvim/lazy-lock.json", true},
+		{"nvim/lua/lazy-lock.json", true},
+		{"lazy-lock.json", false},
+		{"nvim/plugin/a.lua", true},
+		{"nvim/lua/plugin/a.lua", false},
+		{".estragonignore", true},
+		{"nvim/.estragonignore", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			if ignored := ignorer.Ignored(test.file); ignored != test.ignored {
+				t.Errorf("Expected %v, got %v", test.ignored, ignored)
+			}
+		})
+	}
+}
+
+func TestIgnoredDirectoryCantBeIncluded(t *testing.T) {
+	ignorer, err := Ignorer{}.With("", []string{"dir/", "!dir/a"})
+	if err != nil {
+		t.Fatal("Expected nil err")
+	}
+	if !ignorer.Ignored("dir/a") {
+		t.Error("Expected a file in an ignored directory to be ignored")
+	}
+}
+
+func TestInvalidIgnorePattern(t *testing.T) {
+	_, err := Ignorer{}.With("", []string{"[z-a]"})
+	if err == nil {
+		t.Error("Expected non-nil err")
+	}
+}
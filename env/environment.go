@@ -5,6 +5,8 @@ package env
 
 import (
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -17,20 +19,11 @@ func NewEnvironment(env string) Environment {
 	return strings.Fields(env)
 }
 
-// ValidateKey will determine if a key can be split into valid regexp fields.
+// ValidateKey will determine if a key can be parsed into valid conditions,
+// with every regexp field compiling and every group being closed.
 func ValidateKey(key string) bool {
-	pattern := newPattern(key)
-	return allCompile(pattern.good) && allCompile(pattern.bad)
-}
-
-func allCompile(fields []string) bool {
-	for _, field := range fields {
-		_, err := regexp.Compile(field)
-		if err != nil {
-			return false
-		}
-	}
-	return true
+	_, err := parsePattern(key)
+	return err == nil
 }
 
 // Select determines which key in a slice of strings `keys` matches the
@@ -45,8 +38,11 @@ func allCompile(fields []string) bool {
 // wildcard that matches if no other non-wildcard keys match.
 func (env Environment) Select(keys []string) (key string, fields []string) {
 	for _, k := range keys {
-		pattern := newPattern(k)
-		if pattern.wildcard() {
+		pattern, err := parsePattern(k)
+		if err != nil {
+			// Invalid keys never match.
+			continue
+		} else if pattern.wildcard() {
 			// Wildcards are fallbacks.
 			key = k
 		} else {
@@ -60,55 +56,105 @@ func (env Environment) Select(keys []string) (key string, fields []string) {
 	return
 }
 
+// SelectAll determines every key in a slice of strings `keys` that matches the
+// environment, ordered from least to most specific. The wildcard key comes
+// first, followed by the other keys in order of how many fields they contain,
+// counting only the alternative with the fewest, with ties broken by comparing
+// the keys themselves. The fields that matched each key are returned in a slice
+// parallel to the keys.
+func (env Environment) SelectAll(keys []string) (matched []string, fields [][]string) {
+	patterns := make(map[string]pattern, len(keys))
+	sorted := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pattern, err := parsePattern(k)
+		if err == nil {
+			patterns[k] = pattern
+			sorted = append(sorted, k)
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		si, sj := patterns[sorted[i]].size(), patterns[sorted[j]].size()
+		if si != sj {
+			return si < sj
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	for _, k := range sorted {
+		pattern := patterns[k]
+		if pattern.wildcard() {
+			matched = append(matched, k)
+			fields = append(fields, nil)
+		} else if f := env.patternFields(pattern); f != nil {
+			matched = append(matched, k)
+			fields = append(fields, f)
+		}
+	}
+	return
+}
+
 // Matches returns if the passed string s matches the environment as a bool.
 func (env Environment) Matches(s string) bool {
-	pattern := newPattern(s)
+	pattern, err := parsePattern(s)
+	if err != nil {
+		return false
+	}
 	return pattern.wildcard() || env.patternFields(pattern) != nil
 }
 
-// patternFields returns a slice of the feilds in the environment that match the
-// good part of the passed pattern `p` in the order that they match in the
-// pattern. If the pattern does not match the environment, nil is returned.
+// patternFields returns a slice of the fields in the environment that match
+// the first matching alternative of the passed pattern `p`, in the order that
+// they match in the pattern. Only the non-negated conditions contribute fields.
+// If the pattern does not match the environment, nil is returned.
 func (env Environment) patternFields(p pattern) []string {
-	matches := env.matchingFields(p.good)
-	if matches == nil {
-		return nil
-	}
-
-	badMatches := env.matchingFields(p.bad)
-	if badMatches != nil && len(badMatches) > 0 {
-		return nil
+	for _, c := range p.alts {
+		matches := env.clauseFields(c)
+		if matches != nil {
+			return matches
+		}
 	}
-
-	return matches
+	return nil
 }
 
-// matchingFields returns the list of matching fields in the environment as they
-// matched in order. If the environment does not match the pattern (i.e., there
-// are fields that were not matched), nil is returned. An empty slice returns an
-// empty slice.
-func (env Environment) matchingFields(fields []string) []string {
-	matches := make([]string, 0, len(fields))
-
-	for _, field := range fields {
-		f, err := regexp.Compile("^(?:" + field + ")$")
-		if err != nil {
+// clauseFields returns the list of fields in the environment that match the
+// good conditions of a clause in order. If any of the good conditions don't
+// match, or all of the bad conditions do, nil is returned. A clause without
+// good conditions returns an empty slice if it matches.
+func (env Environment) clauseFields(c clause) []string {
+	matches := make([]string, 0, len(c.good))
+	for _, cond := range c.good {
+		fields := env.conditionFields(cond)
+		if fields == nil {
 			return nil
 		}
-		matched := false
-		for _, e := range env {
-			if f.MatchString(e) {
-				matches = append(matches, e)
-				matched = true
-				break
-			}
-		}
-		if !matched {
-			return nil
+		matches = append(matches, fields...)
+	}
+
+	if len(c.bad) == 0 {
+		return matches
+	}
+	for _, cond := range c.bad {
+		if env.conditionFields(cond) == nil {
+			// Not every bad condition matched.
+			return matches
 		}
 	}
+	return nil
+}
 
-	return matches
+// conditionFields returns the fields in the environment that match a single
+// condition, or nil if the condition does not match.
+func (env Environment) conditionFields(c condition) []string {
+	if c.group != nil {
+		return env.patternFields(*c.group)
+	}
+	for _, e := range env {
+		if c.matches(e) {
+			return []string{e}
+		}
+	}
+	return nil
 }
 
 // A Match is the result of an environment matching a key. The subgroups in the
@@ -122,44 +168,109 @@ type Match struct {
 // method run from an Environment. Since the returned values should be from the
 // Select method which returns already valid arguments, an invalid key string
 // will panic.
+//
+// If the key has alternatives, the subgroups are those of the alternative that
+// the fields matched.
 func NewMatch(key string, fields []string) Match {
+	pattern, err := parsePattern(key)
+	if err != nil {
+		panic(err)
+	}
+
 	fieldString := strings.Join(fields, " ")
-	r := strings.Join(newPattern(key).good, " ")
-	r = "^(?:" + r + ")$"
-	regexp := regexp.MustCompile(r)
+	seqs := pattern.sequences()
+	regexps := make([]*regexp.Regexp, 0, len(seqs))
+	for _, seq := range seqs {
+		r := "^(?:" + strings.Join(seq, " ") + ")$"
+		regexps = append(regexps, regexp.MustCompile(r))
+	}
 
-	return Match{fieldString, regexp}
+	for _, r := range regexps {
+		if r.MatchString(fieldString) {
+			return Match{fieldString, r}
+		}
+	}
+	return Match{fieldString, regexps[0]}
 }
 
 // Replace will substitute all of the subgroup matches syntax in the string s.
+// Numbered subgroups are referenced with `$1` or `${1}`, and named subgroups
+// like `(?P<name>...)` are referenced with `$name` or `${name}`. A double
+// dollar sign (`$$`) is replaced with a single one. References to names that
+// aren't subgroups of the match are left untouched so that they can be
+// expanded as environment variables later.
 func (m Match) Replace(s string) string {
-	return m.regexp.ReplaceAllString(m.fields, s)
+	return m.replace(s, false)
 }
 
-// A pattern is a collection of the patterns that the fields in the environment
-// string should or should not pass.
-type pattern struct {
-	good []string
-	bad  []string
+// ReplacePath is like Replace, except that double dollar signs are left
+// untouched. It is meant for strings like paths that will have environment
+// variables expanded later, which is also when the escapes are expected to be
+// replaced.
+func (m Match) ReplacePath(s string) string {
+	return m.replace(s, true)
 }
 
-var loneExclamation = regexp.MustCompile("(^| )!($| )")
+func (m Match) replace(s string, keepEscapes bool) string {
+	submatches := m.regexp.FindStringSubmatch(m.fields)
+	submatch := func(i int) string {
+		if i < len(submatches) {
+			return submatches[i]
+		}
+		return ""
+	}
 
-// newPattern returns a pattern struct given an environment pattern string.
-func newPattern(s string) pattern {
-	parts := loneExclamation.Split(s, 2)
+	var b strings.Builder
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
 
-	good := strings.Fields(parts[0])
-	bad := []string{}
-	if len(parts) == 2 {
-		badPart := loneExclamation.ReplaceAllLiteralString(parts[1], " ")
-		bad = strings.Fields(badPart)
+		if strings.HasPrefix(s, "$$") {
+			if keepEscapes {
+				b.WriteString("$$")
+			} else {
+				b.WriteByte('$')
+			}
+			s = s[2:]
+			continue
+		}
+
+		name, rest, ok := extractRef(s)
+		if !ok {
+			b.WriteByte('$')
+			s = s[1:]
+			continue
+		}
+
+		if n, err := strconv.Atoi(name); err == nil {
+			b.WriteString(submatch(n))
+		} else if n := m.regexp.SubexpIndex(name); n >= 0 {
+			b.WriteString(submatch(n))
+		} else {
+			// Not a subgroup, so leave it for environment variables.
+			b.WriteString(s[:len(s)-len(rest)])
+		}
+		s = rest
 	}
-	return pattern{good, bad}
+
+	return b.String()
 }
 
-// wildcard will return true if the pattern was empty (entirely whitespace) and
-// thus a wildcard pattern.
-func (p pattern) wildcard() bool {
-	return len(p.good) == 0 && len(p.bad) == 0
+var refRegexp = regexp.MustCompile(`^\$(?:\{([A-Za-z0-9_]+)\}|([A-Za-z0-9_]+))`)
+
+// extractRef extracts the name of a reference like `$name` or `${name}` at the
+// start of s, returning the name and the rest of the string after it. If s
+// doesn't start with a reference, the returned bool is false.
+func extractRef(s string) (name string, rest string, ok bool) {
+	m := refRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return "", s, false
+	}
+	name = m[1] + m[2]
+	return name, s[len(m[0]):], true
 }
@@ -58,6 +58,8 @@ func TestValidateKey(t *testing.T) {
 			"good side no problems ! bad[ ]key",
 			false,
 		},
+		{"Invalid key due to an empty alternative", "a ||", false},
+		{"Invalid key due to an empty group", "a ( )", false},
 	}
 
 	for _, test := range tests {
@@ -177,6 +179,41 @@ func TestEnvSelect(t *testing.T) {
 	}
 }
 
+func TestEnvSelectAll(t *testing.T) {
+	keys := []string{
+		"a b",
+		"",
+		"c",
+		"a ! c",
+		"a",
+		"b c ! e",
+		"( a b ) || c",
+	}
+	e := Environment{"a", "b", "c"}
+
+	expectedKeys := []string{"", "( a b ) || c", "a", "c", "a b", "b c ! e"}
+	expectedFields := [][]string{
+		nil,
+		{"a", "b"},
+		{"a"},
+		{"c"},
+		{"a", "b"},
+		{"b", "c"},
+	}
+
+	matched, fields := e.SelectAll(keys)
+	if !reflect.DeepEqual(expectedKeys, matched) {
+		t.Errorf("expected keys to be %#v, got %#v", expectedKeys, matched)
+	}
+	if !reflect.DeepEqual(expectedFields, fields) {
+		t.Errorf(
+			"expected fields to be %#v, got %#v",
+			expectedFields,
+			fields,
+		)
+	}
+}
+
 func TestNewMatch(t *testing.T) {
 	tests := []struct {
 		desc        string
@@ -247,3 +284,42 @@ func TestMatchReplace(t *testing.T) {
 		t.Fatalf("expected %#v, got %#v", expected, replaced)
 	}
 }
+
+func TestMatchReplaceNamed(t *testing.T) {
+	match := NewMatch(
+		"distro:(?P<distro>.+) driver:(.+)",
+		[]string{"distro:arch", "driver:amd"},
+	)
+
+	tests := []struct {
+		desc     string
+		in       string
+		replaced string
+		path     string
+	}{
+		{"Named group", "pkg-${distro}", "pkg-arch", "pkg-arch"},
+		{"Unbraced named group", "$distro-pkg", "arch-pkg", "arch-pkg"},
+		{"Numbered groups", "$1-${2}", "arch-amd", "arch-amd"},
+		{
+			"Environment variables are untouched",
+			"$HOME/${XDG_CONFIG_HOME}/$distro",
+			"$HOME/${XDG_CONFIG_HOME}/arch",
+			"$HOME/${XDG_CONFIG_HOME}/arch",
+		},
+		{"Escaped dollar signs", "$$distro", "$distro", "$$distro"},
+		{"Lone dollar sign", "cost: $", "cost: $", "cost: $"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			replaced := match.Replace(test.in)
+			if replaced != test.replaced {
+				t.Errorf("expected %#v, got %#v", test.replaced, replaced)
+			}
+			path := match.ReplacePath(test.in)
+			if path != test.path {
+				t.Errorf("expected path %#v, got %#v", test.path, path)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package env
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A pattern is a parsed environment key. It matches the environment if any of
+// its alternatives match.
+type pattern struct {
+	alts []clause
+}
+
+// A clause is a collection of the conditions that the fields in the environment
+// string should or should not pass. The clause matches if every good condition
+// matches, unless every bad condition matches as well.
+type clause struct {
+	good []condition
+	bad  []condition
+}
+
+// A condition is a single part of a clause. It is either a regexp that must
+// match an entire field, a comparison against the value of a `field:value`
+// field, or a parenthesized group that is a pattern of its own.
+type condition struct {
+	field  string
+	regexp *regexp.Regexp
+	cmp    *comparison
+	group  *pattern
+}
+
+// A comparison compares the value of a `name:value` field against a constant
+// value with an operator.
+type comparison struct {
+	name, op, value string
+}
+
+var comparisonRegexp = regexp.MustCompile(
+	`^([A-Za-z0-9_.-]+)(>=|<=|==|!=|>|<)([A-Za-z0-9_.+~-]+)$`,
+)
+
+// parsePattern parses an environment key into a pattern. Fields are separated
+// by spaces. A lone `||` separates alternatives, a lone `(` and `)` group
+// conditions, and the first lone `!` of a clause separates the good conditions
+// from the bad ones. Any other field is either a comparison, like `version>=12`,
+// or a regexp. An error is returned if a group is not closed, a group or an
+// alternative is empty, or a regexp doesn't compile.
+func parsePattern(s string) (pattern, error) {
+	p := parser{key: s, tokens: strings.Fields(s)}
+	pattern, err := p.parseAlts()
+	if err != nil {
+		return pattern, err
+	}
+	if p.pos < len(p.tokens) {
+		return pattern, fmt.Errorf(`Unmatched ")" in key "%s"`, s)
+	}
+	return pattern, nil
+}
+
+type parser struct {
+	key    string
+	tokens []string
+	pos    int
+}
+
+func (p *parser) parseAlts() (pattern, error) {
+	var pat pattern
+	for {
+		c, err := p.parseClause()
+		if err != nil {
+			return pat, err
+		}
+		pat.alts = append(pat.alts, c)
+
+		if p.pos < len(p.tokens) && p.tokens[p.pos] == "||" {
+			p.pos++
+		} else if len(pat.alts) > 1 && pat.hasEmptyAlt() {
+			// An empty alternative would match every environment.
+			return pat, fmt.Errorf(
+				`Empty alternative in key "%s"`,
+				p.key,
+			)
+		} else {
+			return pat, nil
+		}
+	}
+}
+
+func (p *parser) parseClause() (clause, error) {
+	var c clause
+	negated := false
+
+	for p.pos < len(p.tokens) {
+		var cond condition
+		switch tok := p.tokens[p.pos]; tok {
+		case "||", ")":
+			return c, nil
+		case "!":
+			// All lone exclamation marks after the first are ignored.
+			negated = true
+			p.pos++
+			continue
+		case "(":
+			p.pos++
+			group, err := p.parseAlts()
+			if err != nil {
+				return c, err
+			}
+			if p.pos >= len(p.tokens) {
+				return c, fmt.Errorf(`Unmatched "(" in key "%s"`, p.key)
+			}
+			if group.hasEmptyAlt() {
+				return c, fmt.Errorf(`Empty group in key "%s"`, p.key)
+			}
+			cond.group = &group
+		default:
+			var err error
+			cond, err = newCondition(tok)
+			if err != nil {
+				return c, err
+			}
+		}
+		p.pos++
+
+		if negated {
+			c.bad = append(c.bad, cond)
+		} else {
+			c.good = append(c.good, cond)
+		}
+	}
+
+	return c, nil
+}
+
+// hasEmptyAlt returns if any alternative of the pattern has no conditions.
+func (p pattern) hasEmptyAlt() bool {
+	for _, c := range p.alts {
+		if len(c.good) == 0 && len(c.bad) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// newCondition creates a condition for a single field of a key.
+func newCondition(field string) (condition, error) {
+	if m := comparisonRegexp.FindStringSubmatch(field); m != nil {
+		return condition{cmp: &comparison{m[1], m[2], m[3]}}, nil
+	}
+
+	r, err := regexp.Compile("^(?:" + field + ")$")
+	if err != nil {
+		return condition{}, fmt.Errorf(
+			`Invalid regexp "%s" in key field: %w`,
+			field,
+			err,
+		)
+	}
+	return condition{field: field, regexp: r}, nil
+}
+
+// matches returns if a single field of the environment passes the condition.
+// Groups are never matched by a single field.
+func (c condition) matches(field string) bool {
+	switch {
+	case c.regexp != nil:
+		return c.regexp.MatchString(field)
+	case c.cmp != nil:
+		return c.cmp.matches(field)
+	default:
+		return false
+	}
+}
+
+// sequences returns the regexps that the fields matched by the condition
+// match, one sequence per way that the condition could have matched.
+func (c condition) sequences() [][]string {
+	switch {
+	case c.regexp != nil:
+		return [][]string{{c.field}}
+	case c.cmp != nil:
+		r := "(?:" + regexp.QuoteMeta(c.cmp.name) + `:\S*)`
+		return [][]string{{r}}
+	default:
+		return c.group.sequences()
+	}
+}
+
+// sequences returns every sequence of regexps that the fields matched by the
+// pattern could correspond to, one for each alternative after expanding the
+// alternatives of nested groups. The regexps of the bad conditions are not
+// included since they don't contribute fields.
+func (p pattern) sequences() [][]string {
+	seqs := make([][]string, 0, len(p.alts))
+	for _, c := range p.alts {
+		clauseSeqs := [][]string{{}}
+		for _, cond := range c.good {
+			product := make([][]string, 0, len(clauseSeqs))
+			for _, prefix := range clauseSeqs {
+				for _, suffix := range cond.sequences() {
+					seq := make([]string, 0, len(prefix)+len(suffix))
+					seq = append(seq, prefix...)
+					seq = append(seq, suffix...)
+					product = append(product, seq)
+				}
+			}
+			clauseSeqs = product
+		}
+		seqs = append(seqs, clauseSeqs...)
+	}
+	return seqs
+}
+
+// size is the number of conditions in the least specific alternative of the
+// pattern, including those nested in groups, which is used as a measure of how
+// specific the pattern is. An alternation is only as specific as the branch
+// that is easiest to match.
+func (p pattern) size() int {
+	size := -1
+	for _, c := range p.alts {
+		n := 0
+		for _, cond := range append(c.good, c.bad...) {
+			if cond.group != nil {
+				n += cond.group.size()
+			} else {
+				n++
+			}
+		}
+		if size == -1 || n < size {
+			size = n
+		}
+	}
+	return size
+}
+
+// wildcard will return true if the pattern was empty (entirely whitespace) and
+// thus a wildcard pattern.
+func (p pattern) wildcard() bool {
+	return p.size() == 0
+}
+
+// matches returns if a field is of the form `name:value` where the name is the
+// name of the comparison and the value compares to the comparison's value
+// according to its operator.
+func (c comparison) matches(field string) bool {
+	name, value, ok := strings.Cut(field, ":")
+	if !ok || name != c.name {
+		return false
+	}
+
+	cmp := compareVersions(value, c.value)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "==":
+		return cmp == 0
+	default:
+		return cmp != 0
+	}
+}
+
+// compareVersions compares two dot-separated version strings, returning a
+// negative number if a < b, a positive number if a > b, and zero if they are
+// equal. Parts that are both integers are compared numerically, and other
+// parts are compared as strings. Missing parts are treated as "0", so "12" and
+// "12.0" are equal.
+func compareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for len(as) < len(bs) {
+		as = append(as, "0")
+	}
+	for len(bs) < len(as) {
+		bs = append(bs, "0")
+	}
+
+	for i := range as {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		if aErr == nil && bErr == nil {
+			if an != bn {
+				return an - bn
+			}
+		} else if cmp := strings.Compare(as[i], bs[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+package env
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePatternErrors(t *testing.T) {
+	tests := []struct {
+		desc string
+		in   string
+		err  bool
+	}{
+		{"Empty key", "", false},
+		{"Group", "( a b ) || c", false},
+		{"Nested groups", "( ( a || b ) c ) ! d", false},
+		{"Comparison", "version>=12", false},
+		{"Regexp with a group", "(a|b) c", false},
+		{"Named group", "(?P<distro>arch|debian)", false},
+		{"Unclosed group", "( a b", true},
+		{"Unopened group", "a b )", true},
+		{"Bad regexp in group", "( a[ )", true},
+		{"Trailing alternative", "a ||", true},
+		{"Leading alternative", "|| a", true},
+		{"Only alternatives", "||", true},
+		{"Empty group", "( )", true},
+		{"Empty alternative in group", "( a || ) b", true},
+		{"Negated alternative", "a || ! b", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := parsePattern(test.in)
+			if err == nil && test.err {
+				t.Error("expected err to be non-nil, was nil")
+			} else if err != nil && !test.err {
+				t.Error("expected err to be nil, was " + err.Error())
+			}
+		})
+	}
+}
+
+func TestPatternMatches(t *testing.T) {
+	e := Environment{"debian", "version:12.1", "laptop", "work"}
+
+	good := []string{
+		"version>=12",
+		"version>12",
+		"version<13",
+		"version==12.1",
+		"version!=12",
+		"debian version>=12",
+		"( laptop work ) || desktop",
+		"desktop || ( laptop work )",
+		"( arch || debian ) laptop",
+		"debian ! ( desktop || server )",
+		"debian ! laptop server",
+	}
+
+	for _, g := range good {
+		if !e.Matches(g) {
+			t.Errorf(`Expected environment to match "%s"`, g)
+		}
+	}
+
+	bad := []string{
+		"version>=13",
+		"version<12",
+		"version==12",
+		"release>=1",
+		"( laptop desktop ) || server",
+		"( arch || ubuntu ) laptop",
+		"debian ! ( desktop || work )",
+		"debian ! laptop work",
+		"( laptop",
+		"( )",
+		"debian ||",
+	}
+
+	for _, b := range bad {
+		if e.Matches(b) {
+			t.Errorf(`Expected environment to not match "%s"`, b)
+		}
+	}
+}
+
+func TestPatternSelectFields(t *testing.T) {
+	e := Environment{"server", "distro:arch", "version:3"}
+
+	key, fields := e.Select([]string{
+		"desktop || ( distro:(.*) version>=2 )",
+	})
+	expected := []string{"distro:arch", "version:3"}
+	if !reflect.DeepEqual(expected, fields) {
+		t.Fatalf("expected %#v, got %#v", expected, fields)
+	}
+
+	replaced := NewMatch(key, fields).Replace("$1-pkg")
+	if replaced != "arch-pkg" {
+		t.Errorf(`expected "arch-pkg", got "%s"`, replaced)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		cmp  int
+	}{
+		{"12", "12", 0},
+		{"12", "12.0", 0},
+		{"12.1", "12", 1},
+		{"9", "12", -1},
+		{"1.10", "1.9", 1},
+		{"bookworm", "bullseye", -1},
+	}
+
+	for _, test := range tests {
+		cmp := compareVersions(test.a, test.b)
+		if (cmp < 0) != (test.cmp < 0) || (cmp > 0) != (test.cmp > 0) {
+			t.Errorf(
+				"expected compareVersions(%#v, %#v) to be %d, got %d",
+				test.a,
+				test.b,
+				test.cmp,
+				cmp,
+			)
+		}
+	}
+}
@@ -26,6 +26,20 @@ func main() {
 		return
 	}
 
+	if args.subcommand == "matrix" ||
+		args.subcommand == "test" ||
+		args.subcommand == "check" {
+		err = runReadOnly(args)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(2)
+		}
+		return
+	} else if len(args.envs) > 1 {
+		fmt.Fprintln(os.Stderr, "Only matrix accepts multiple --env flags")
+		os.Exit(1)
+	}
+
 	if args.dry && args.subcommand != "envvar" {
 		fmt.Println("Running in dry mode, no changes will be made")
 	}
@@ -36,7 +50,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	env, err := getEnv(args.env, dir)
+	argEnv := ""
+	if len(args.envs) == 1 {
+		argEnv = args.envs[0]
+	}
+	env, err := getEnv(argEnv, dir)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error getting environment:", err)
 		os.Exit(1)
@@ -52,13 +70,30 @@ func main() {
 		fmt.Printf("Using environment: %s\n\n", env)
 	}
 
-	runner := subcmd.NewSubcmdRunner(conf, dir, args.dry, args.force)
-
-	dots := removeDuplicates(args.dots)
+	runner := subcmd.NewSubcmdRunner(
+		conf,
+		dir,
+		args.dry,
+		args.force,
+		args.strict,
+	)
 
-	if args.all {
-		dots = append(conf.AllDots(), dots...)
-		dots = removeDuplicates(dots)
+	if args.subcommand == "ls" && !args.selects() {
+		// List every dot by default.
+		args.all = true
+	}
+	all := conf.AllDots()
+	if args.subcommand == "ls" {
+		// Show the dots that don't apply along with why.
+		all = conf.DefinedDots()
+	}
+	dots := selectDots(conf, args, all)
+	if args.subcommand == "envvar" {
+		// The arguments are environment variables instead of dots.
+		dots = removeDuplicates(args.dots)
+	} else if args.subcommand == "relocate" {
+		// The argument is the old directory instead of dots.
+		dots = args.dots
 	}
 
 	err = runner.RunSubcmd(args.subcommand, dots)
@@ -68,10 +103,87 @@ func main() {
 	}
 }
 
+// runReadOnly runs the subcommands that never change the filesystem, so the
+// directory is not initialized and the environment is not stored.
+func runReadOnly(args cmdArgs) error {
+	dir, err := findDir(args.dir)
+	if err != nil {
+		return err
+	}
+
+	conf, err := getConfig(dir, "")
+	if err != nil && args.subcommand != "check" {
+		// The checker reports invalid configs by itself.
+		return err
+	}
+
+	runner := subcmd.NewSubcmdRunner(conf, dir, true, false, false)
+
+	if args.subcommand == "check" {
+		return runner.Check()
+	} else if args.subcommand == "test" {
+		// The arguments are test files instead of dots.
+		return runner.Test(args.dots)
+	}
+
+	if !args.selects() {
+		args.all = true
+	}
+	// Every dot is compared, even if it doesn't apply without an
+	// environment.
+	dots := selectDots(conf, args, conf.DefinedDots())
+
+	return runner.Matrix(args.envs, dots, args.diff)
+}
+
 type cmdArgs struct {
-	subcommand, dir, env string
-	dry, force, all      bool
-	dots                 []string
+	subcommand, dir       string
+	dry, force, all, diff bool
+	strict                bool
+	envs, dots            []string
+	tags, excludeTags     []string
+}
+
+// selects returns if the arguments select any dots, either by name, by tag, or
+// with --all.
+func (args cmdArgs) selects() bool {
+	return args.all || len(args.dots) > 0 || len(args.tags) > 0
+}
+
+// selectDots returns the dots selected by the arguments: the `all` dots if
+// --all was passed, the dots with any of the --tag tags, and the listed dots,
+// where a dot of the form @tag is replaced with every dot that has the tag. The
+// dots with any of the --exclude-tag tags are then removed.
+func selectDots(conf config.Config, args cmdArgs, all []string) []string {
+	var dots []string
+	if args.all {
+		dots = append(dots, all...)
+	}
+	for _, tag := range args.tags {
+		dots = append(dots, conf.TaggedDots(tag)...)
+	}
+	for _, dot := range args.dots {
+		if tag, ok := strings.CutPrefix(dot, "@"); ok {
+			dots = append(dots, conf.TaggedDots(tag)...)
+		} else {
+			dots = append(dots, dot)
+		}
+	}
+
+	excluded := make(map[string]struct{})
+	for _, tag := range args.excludeTags {
+		for _, dot := range conf.TaggedDots(tag) {
+			excluded[dot] = struct{}{}
+		}
+	}
+
+	selected := make([]string, 0, len(dots))
+	for _, dot := range removeDuplicates(dots) {
+		if _, ok := excluded[dot]; !ok {
+			selected = append(selected, dot)
+		}
+	}
+	return selected
 }
 
 func parseFlags() (args cmdArgs, err error) {
@@ -86,13 +198,30 @@ func parseFlags() (args cmdArgs, err error) {
 			"  deploy   - Deploy the files in the dot folders",
 			"  undeploy - Delete files that were previously deployed",
 			"  redeploy - Undeploy, then deploy each dot",
+			"  explain  - Show why each setting of a dot was chosen",
+			"  ls       - List the selected dots and their tags",
+			"  status   - Show the state of the files owned by each dot",
+			"  matrix   - Compare dots across several environments",
+			"  test     - Check the config against estragon.test.yaml",
+			"  check    - Find problems in estragon.yaml",
 			"  envvar   - Set and print local environment variables",
+			"  relocate - Update owned links after moving the directory",
 			"  help     - Display this message",
 			"",
 			"All subcommands take a list of dots except for envvar,",
 			"which takes strings without equal signs to print an",
 			"environment value, with equal signs to set them to new",
-			"values, and with a minus (-) after the name to remove them",
+			"values, and with a minus (-) after the name to remove them,",
+			"test, which takes a list of test files, and relocate,",
+			"which takes the directory the dots were moved from",
+			"(the recorded one by default)",
+			"",
+			"A dot of the form @tag is replaced with every dot that",
+			"has the tag",
+			"",
+			"The matrix subcommand takes an --env flag for every",
+			"environment or profile name to compare, and uses every",
+			"profile in estragon.yaml if there are none",
 			"",
 			"A lack of a subcommand will print the ownership of",
 			"the dots (all of them by default) and store the",
@@ -113,10 +242,10 @@ func parseFlags() (args cmdArgs, err error) {
 		"The `directory` containing the dots (current one by default)",
 	)
 
-	env := subcmdFlags.StringP(
+	envs := subcmdFlags.StringArrayP(
 		"env",
 		"e",
-		"",
+		nil,
 		"The `environment` string used in environment matching",
 	)
 
@@ -141,6 +270,31 @@ func parseFlags() (args cmdArgs, err error) {
 		"Add all dots defined in estragon.yaml to the dot list",
 	)
 
+	tags := subcmdFlags.StringArrayP(
+		"tag",
+		"t",
+		nil,
+		"Add all dots with the `tag` to the dot list",
+	)
+
+	excludeTags := subcmdFlags.StringArray(
+		"exclude-tag",
+		nil,
+		"Remove all dots with the `tag` from the dot list",
+	)
+
+	strict := subcmdFlags.Bool(
+		"strict",
+		false,
+		"Fail on dots with missing required commands or paths",
+	)
+
+	diff := subcmdFlags.Bool(
+		"diff",
+		false,
+		"Only show the differences between environments in matrix",
+	)
+
 	var argList []string
 
 	if len(os.Args) < 2 {
@@ -164,45 +318,92 @@ func parseFlags() (args cmdArgs, err error) {
 	}
 
 	args.dir = *dir
-	args.env = *env
+	args.envs = *envs
 	args.dry = *dry
 	args.force = *force
 	args.all = *all
+	args.diff = *diff
+	args.strict = *strict
+	args.tags = *tags
+	args.excludeTags = *excludeTags
 	args.dots = subcmdFlags.Args()
 	return
 }
 
-func initDir(argDir string) (dir string, err error) {
-	dir = argDir
-	wd, err := os.Getwd()
-	if err != nil {
-		return
+// findDir finds the directory containing estragon.yaml. A directory passed as
+// an argument is used as is. Otherwise, the search starts from the current
+// directory and goes through its parents, stopping at the first estragon.yaml
+// unless it is the config fragment of a dot of the config above it.
+func findDir(argDir string) (dir string, err error) {
+	if argDir != "" {
+		dir, err = filepath.Abs(argDir)
+		if err != nil {
+			return
+		}
+		estragonYaml := filepath.Join(dir, "estragon.yaml")
+		if _, err := os.Stat(estragonYaml); errors.Is(err, os.ErrNotExist) {
+			return dir, errors.New("No estragon.yaml file in " + argDir)
+		} else if err != nil {
+			return dir, err
+		}
+		return dir, nil
 	}
 
-	if dir == "" {
-		dir = wd
-	} else if filepath.IsLocal(dir) {
-		dir = filepath.Join(wd, dir)
+	dir, err = os.Getwd()
+	if err != nil {
+		return
 	}
 
-	for dir != filepath.Dir(dir) {
+	found := ""
+	for {
 		estragonYaml := filepath.Join(dir, "estragon.yaml")
-		if _, err := os.Stat(estragonYaml); errors.Is(err, os.ErrNotExist) {
-			dir = filepath.Dir(dir)
-		} else if err != nil {
+		if _, err := os.Stat(estragonYaml); err == nil {
+			if found == "" {
+				found = dir
+			} else {
+				if isFragmentOf(found, dir) {
+					found = dir
+				}
+				break
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
 			return dir, err
-		} else {
-			// Found the file.
+		}
+
+		if dir == filepath.Dir(dir) {
 			break
 		}
+		dir = filepath.Dir(dir)
 	}
 
-	estragonYaml := filepath.Join(dir, "estragon.yaml")
-	if _, err := os.Stat(estragonYaml); errors.Is(err, os.ErrNotExist) {
+	if found == "" {
 		err = errors.New("No estragon.yaml file in directory or parents")
 		return dir, err
-	} else if err != nil {
-		return dir, err
+	}
+
+	return found, nil
+}
+
+// isFragmentOf returns if the estragon.yaml in `dir` is the config fragment of
+// a dot of the config in `parent`.
+func isFragmentOf(dir, parent string) bool {
+	fragmentDirs, err := config.FragmentDirs(parent)
+	if err != nil {
+		// A config that can't be read doesn't claim any fragments.
+		return false
+	}
+	for _, d := range fragmentDirs {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func initDir(argDir string) (dir string, err error) {
+	dir, err = findDir(argDir)
+	if err != nil {
+		return
 	}
 
 	estragonDir := filepath.Join(dir, ".estragon")
@@ -229,6 +430,18 @@ func initDir(argDir string) (dir string, err error) {
 		return dir, err
 	}
 
+	dirFile := filepath.Join(estragonDir, "dir")
+	if _, err := os.Stat(dirFile); errors.Is(err, os.ErrNotExist) {
+		// Record where the directory is, so that moving it can be
+		// noticed.
+		err = os.WriteFile(dirFile, []byte(dir), 0666)
+		if err != nil {
+			return dir, err
+		}
+	} else if err != nil {
+		return dir, err
+	}
+
 	envvars := filepath.Join(estragonDir, "envvars")
 	f, err := os.OpenFile(envvars, os.O_RDONLY|os.O_CREATE, 0666)
 	if err != nil {
@@ -262,17 +475,8 @@ func getEnv(argEnv, dir string) (env string, err error) {
 }
 
 func getConfig(dir, envString string) (conf config.Config, err error) {
-	confFile := filepath.Join(dir, "estragon.yaml")
-
-	f, err := os.ReadFile(confFile)
-	if err != nil {
-		return
-	}
-
 	environment := env.NewEnvironment(envString)
-	conf, err = config.NewConfig(f, environment)
-
-	return
+	return config.LoadConfig(dir, environment)
 }
 
 func removeDuplicates(s []string) []string {
@@ -0,0 +1,42 @@
+package subcmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aus-hawk/estragon/config"
+)
+
+// Check lints estragon.yaml and the files it is split across, printing each
+// problem found with the file, line, and column it was found at. If there are
+// any problems, a non-nil error is returned.
+func (s SubcmdRunner) Check() error {
+	err := s.setEnvvars()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	lookupEnv := func(k string) bool {
+		_, ok := os.LookupEnv(k)
+		return ok
+	}
+
+	problems, err := config.LintDir(s.dir, lookupEnv)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range problems {
+		if p.File != "" {
+			p.File = filepath.Join(s.dir, p.File)
+		}
+		fmt.Println(p)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Found %d problem(s)", len(problems))
+	}
+	fmt.Println("No problems found")
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/aus-hawk/estragon/config"
@@ -14,6 +15,7 @@ import (
 
 type DotfileDeployer struct {
 	conf     config.DotConfig
+	dotRoot  string
 	resolver dotfile.Resolver
 	expand   dotfile.PathExpander
 	own      OwnershipManager
@@ -30,7 +32,45 @@ func NewDotfileDeployer(
 	dry bool,
 ) DotfileDeployer {
 	resolver := dotfile.NewResolver(dotRoot, conf.Root, conf.DotPrefix, expand)
-	return DotfileDeployer{conf, resolver, expand, own, dry}
+	return DotfileDeployer{conf, dotRoot, resolver, expand, own, dry}
+}
+
+// A deployment is a file, its name within the dot, and where it is deployed,
+// the method it is deployed with, and the mode, owner, and group it is given,
+// which are empty to keep the defaults. A folded deployment links a directory
+// in place of the files in it.
+type deployment struct {
+	source string
+	target string
+	file   string
+	method string
+	mode   string
+	owner  string
+	group  string
+	folded bool
+}
+
+// WithSources returns a copy of the deployer that finds the files that are keys
+// of `sources` at their values instead of within the dot root.
+func (d DotfileDeployer) WithSources(sources map[string]string) DotfileDeployer {
+	d.resolver = d.resolver.WithSources(sources)
+	return d
+}
+
+// WithAlternates returns a copy of the deployer that picks between the
+// alternates of files using `selectAlternate`.
+func (d DotfileDeployer) WithAlternates(
+	selectAlternate dotfile.AlternateSelector,
+) DotfileDeployer {
+	d.resolver = d.resolver.WithAlternates(selectAlternate)
+	return d
+}
+
+// WithIgnorer returns a copy of the deployer that leaves out the files that
+// `ignorer` ignores.
+func (d DotfileDeployer) WithIgnorer(ignorer dotfile.Ignorer) DotfileDeployer {
+	d.resolver = d.resolver.WithIgnorer(ignorer)
+	return d
 }
 
 // DeployFiles either copies or creates links of files within the dot file tree
@@ -55,80 +95,353 @@ func (d DotfileDeployer) DeployFiles(dot string, files []string) error {
 		}
 		fmt.Println("Root:", expandedRoot)
 		fmt.Println("Dot prefix:", d.conf.DotPrefix)
+		fmt.Println("Link style:", d.conf.LinkStyle)
+		if d.conf.Fold {
+			fmt.Println("Fold: true")
+		}
+		if d.conf.Mode != "" {
+			fmt.Println("Mode:", d.conf.Mode)
+		}
+		if d.conf.Owner != "" {
+			fmt.Println("Owner:", d.conf.Owner)
+		}
+		if d.conf.Group != "" {
+			fmt.Println("Group:", d.conf.Group)
+		}
 		if len(rules) > 0 {
 			fmt.Println("Rules:")
 			for k, v := range rules {
-				fmt.Printf("  %s -> %s\n", k, v)
+				fmt.Printf(
+					"  %s -> %s%s\n",
+					k,
+					v,
+					describeRuleOptions(d.conf.RuleOptions[k]),
+				)
+				for _, t := range d.conf.FanOut[k] {
+					fmt.Printf(
+						"  %s -> %s%s\n",
+						k,
+						t.Target,
+						describeRuleOptions(t.RuleOptions),
+					)
+				}
 			}
 		}
 	}
 
-	fileMap, err := d.resolve(files, rules)
+	deployments, err := d.resolve(files, rules)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println()
 
-	if len(fileMap) == 0 {
+	if len(deployments) == 0 {
 		// No deployable files is not an error.
 		fmt.Println("No files to deploy")
 		return nil
 	}
 
-	outFiles := make([]string, 0, len(fileMap))
-	for _, outFile := range fileMap {
-		outFiles = append(outFiles, outFile)
+	if os.Geteuid() != 0 {
+		// Only what is applied is recorded as owned.
+		for i, dep := range deployments {
+			if dep.owner != "" || dep.group != "" {
+				fmt.Println(
+					"Not running as root, skipping the owner and group of",
+					dep.target,
+				)
+				deployments[i].owner, deployments[i].group = "", ""
+			}
+		}
 	}
-	err = d.own.EnsureOwnership(outFiles, dot)
+
+	if d.conf.Fold {
+		dotOwn, err := d.own.OwnedFiles()
+		if err != nil {
+			return err
+		}
+		deployments, err = foldDeployments(
+			deployments,
+			d.dotRoot,
+			dotOwn[dot],
+		)
+		if err != nil {
+			return err
+		}
+	}
+	err = d.unfoldParents(deployments)
 	if err != nil {
 		return err
 	}
 
-	return d.deploy(fileMap)
+	outFiles := make([]OwnedFile, 0, len(deployments))
+	for i, dep := range deployments {
+		if dep.method == "hardlink" {
+			same, err := sameFilesystem(dep.source, dep.target)
+			if err != nil {
+				return err
+			} else if !same {
+				fmt.Println(
+					"Warning:",
+					dep.target,
+					"is on a different filesystem than",
+					dep.source,
+					"so it is copied instead of hard linked",
+				)
+				deployments[i].method = "copy"
+				dep.method = "copy"
+			}
+		}
+
+		file := OwnedFile{
+			Target:   dep.target,
+			Source:   dep.source,
+			Mode:     dep.mode,
+			Owner:    dep.owner,
+			Group:    dep.group,
+			Hardlink: dep.method == "hardlink",
+			Folded:   dep.folded,
+		}
+		if file.Hardlink {
+			// A hard link is its source, so it isn't given a mode or
+			// owner of its own.
+			file.Mode, file.Owner, file.Group = "", "", ""
+		} else if dep.method != "copy" {
+			// Neither is a link given a mode.
+			file.Mode = ""
+		}
+		outFiles = append(outFiles, file)
+	}
+	if d.dry {
+		err = d.own.CheckOwnership(outFiles, dot)
+	} else {
+		err = d.own.EnsureOwnership(outFiles, dot)
+	}
+	if err != nil {
+		return err
+	}
+
+	return d.deploy(deployments)
 }
 
+// resolve resolves where and how each of the files is deployed, sorted by the
+// files and then their targets, leaving out the ones whose method is "none".
+// Each target after the first of a rule is resolved as if it were the only one.
 func (d DotfileDeployer) resolve(
 	files []string,
 	rules map[string]string,
-) (map[string]string, error) {
+) ([]deployment, error) {
+	deployments, err := d.resolveTargets(
+		files,
+		rules,
+		d.conf.RuleOptions,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; ; i++ {
+		fanRules := make(map[string]string, len(rules))
+		for k, v := range rules {
+			fanRules[k] = v
+		}
+		options := make(map[string]config.RuleOptions)
+		only := make(map[string]bool)
+		for k, targets := range d.conf.FanOut {
+			if i < len(targets) {
+				fanRules[k] = targets[i].Target
+				options[k] = targets[i].RuleOptions
+				only[k] = true
+			}
+		}
+		if len(only) == 0 {
+			break
+		}
+
+		fanned, err := d.resolveTargets(files, fanRules, options, only)
+		if err != nil {
+			return nil, err
+		}
+		deployments = append(deployments, fanned...)
+	}
+
+	sort.Slice(deployments, func(i, j int) bool {
+		a, b := deployments[i], deployments[j]
+		if a.source != b.source {
+			return a.source < b.source
+		}
+		return a.target < b.target
+	})
+	return deployments, nil
+}
+
+// resolveTargets resolves the files with the rules, using the options of the
+// rules. If `only` isn't nil, only the files placed by the rules that are its
+// keys are returned.
+func (d DotfileDeployer) resolveTargets(
+	files []string,
+	rules map[string]string,
+	options map[string]config.RuleOptions,
+	only map[string]bool,
+) ([]deployment, error) {
+	dotPrefixes := make(map[string]bool)
+	shallowRules := make(map[string]bool)
+	for key, o := range options {
+		if o.Method != "" && !validMethod(o.Method) {
+			return nil, errors.New(o.Method + " is not a valid method")
+		}
+
+		switch o.Method {
+		case "shallow":
+			shallowRules[key] = true
+		case "deep", "copy", "hardlink":
+			shallowRules[key] = false
+		}
+
+		if o.DotPrefix != nil {
+			dotPrefixes[key] = *o.DotPrefix
+		} else if d.conf.Method == "shallow" && o.Method != "shallow" {
+			// Files placed one by one in a shallow dot still follow
+			// the dot prefix setting.
+			dotPrefixes[key] = d.conf.DotPrefix
+		}
+	}
+	resolver := d.resolver.WithDotPrefixes(dotPrefixes).WithShallowRules(
+		shallowRules,
+	)
+
+	var placements map[string]dotfile.Placement
+	var err error
 	switch d.conf.Method {
-	case "deep", "copy":
-		return d.resolver.DeepResolve(files, rules)
+	case "deep", "copy", "hardlink", "none":
+		placements, err = resolver.DeepPlace(files, rules)
 	case "shallow":
 		if files != nil {
 			// nil files means the directory doesn't exist and we
 			// shouldn't link to a non-existent directory.
-			return d.resolver.ShallowResolve(files, rules)
-		} else {
-			return nil, nil
+			placements, err = resolver.ShallowPlace(files, rules)
 		}
-	case "none":
-		return nil, nil
 	default:
 		return nil, errors.New(d.conf.Method + " is not a valid method")
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	deployments := make([]deployment, 0, len(placements))
+	for file, p := range placements {
+		if only != nil && !only[p.Rule] {
+			continue
+		}
+
+		dep := deployment{
+			file,
+			p.Target,
+			p.File,
+			d.conf.Method,
+			d.conf.Mode,
+			d.conf.Owner,
+			d.conf.Group,
+			false,
+		}
+		if o, ok := options[p.Rule]; ok && p.Rule != "" {
+			if o.Method != "" {
+				dep.method = o.Method
+			}
+			if o.Mode != "" {
+				dep.mode = o.Mode
+			}
+			if o.Owner != "" {
+				dep.owner = o.Owner
+			}
+			if o.Group != "" {
+				dep.group = o.Group
+			}
+		}
+		if dep.mode != "" {
+			if _, err := config.ParseMode(dep.mode); err != nil {
+				return nil, err
+			}
+		}
+
+		if dep.method != "none" {
+			deployments = append(deployments, dep)
+		}
+	}
+	return deployments, nil
 }
 
-func (d DotfileDeployer) deploy(fileMap map[string]string) error {
-	switch d.conf.Method {
-	case "deep", "shallow":
+// deploy creates the links, hard links, and copies of the deployments, along
+// with their missing parent directories.
+func (d DotfileDeployer) deploy(deployments []deployment) error {
+	links := make([]deployment, 0)
+	hardlinks := make([]deployment, 0)
+	copies := make([]deployment, 0)
+	for _, dep := range deployments {
+		switch dep.method {
+		case "copy":
+			copies = append(copies, dep)
+		case "hardlink":
+			hardlinks = append(hardlinks, dep)
+		default:
+			links = append(links, dep)
+		}
+	}
+
+	if len(links) > 0 {
 		fmt.Println("Creating the following symlinks (link -> original):")
-		for dotfile, link := range fileMap {
-			fmt.Printf("  %s -> %s\n", link, dotfile)
+		for _, dep := range links {
+			fmt.Printf("  %s -> %s\n", dep.target, dep.source)
 			if !d.dry {
-				err := symlink(dotfile, link)
+				err := makeParents(dep)
+				if err != nil {
+					return err
+				}
+				err = os.Symlink(
+					linkDestination(dep.source, dep.target, d.conf.LinkStyle),
+					dep.target,
+				)
+				if err != nil {
+					return err
+				}
+				err = chown(dep.target, dep.owner, dep.group)
 				if err != nil {
 					return err
 				}
 			}
 		}
-	case "copy":
+	}
+	if len(hardlinks) > 0 {
+		fmt.Println("Creating the following hard links (link -> original):")
+		for _, dep := range hardlinks {
+			fmt.Printf("  %s -> %s\n", dep.target, dep.source)
+			if !d.dry {
+				err := makeParents(dep)
+				if err != nil {
+					return err
+				}
+				err = os.Link(dep.source, dep.target)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if len(copies) > 0 {
 		fmt.Println("Copying the following files (original -> copy):")
-		for dotfile, copyFile := range fileMap {
-			fmt.Printf("  %s -> %s\n", dotfile, copyFile)
+		for _, dep := range copies {
+			fmt.Printf("  %s -> %s\n", dep.source, dep.target)
 			if !d.dry {
-				err := copyDotfile(dotfile, copyFile)
+				err := makeParents(dep)
+				if err != nil {
+					return err
+				}
+				err = copyDotfile(dep.source, dep.target, dep.mode)
+				if err != nil {
+					return err
+				}
+				err = chown(dep.target, dep.owner, dep.group)
 				if err != nil {
 					return err
 				}
@@ -139,27 +452,129 @@ func (d DotfileDeployer) deploy(fileMap map[string]string) error {
 	return nil
 }
 
-func symlink(file, link string) error {
-	err := os.MkdirAll(filepath.Dir(link), 0777)
+// validMethod returns if `method` is one of the methods of the config.
+func validMethod(method string) bool {
+	for _, m := range config.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// describeRuleOptions describes the settings that the options of a rule
+// override, or returns an empty string if there are none.
+func describeRuleOptions(o config.RuleOptions) string {
+	settings := make([]string, 0)
+	if o.Method != "" {
+		settings = append(settings, "method "+o.Method)
+	}
+	if o.Mode != "" {
+		settings = append(settings, "mode "+o.Mode)
+	}
+	if o.Owner != "" {
+		settings = append(settings, "owner "+o.Owner)
+	}
+	if o.Group != "" {
+		settings = append(settings, "group "+o.Group)
+	}
+	if o.DotPrefix != nil {
+		settings = append(settings, fmt.Sprintf("dot prefix %v", *o.DotPrefix))
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(settings, ", ") + ")"
+}
+
+// linkDestination returns what a link at `link` to the file `file` points to
+// with the link style `style`. A relative link is relative to the directory
+// that the link is really in, after following symlinks, since that is where
+// it is resolved from. If there is no relative path, the link is absolute.
+func linkDestination(file, link, style string) string {
+	if style != "relative" {
+		return file
+	}
+
+	dir := filepath.Dir(link)
+	if realDir, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = realDir
+	}
+	rel, err := filepath.Rel(dir, file)
 	if err != nil {
-		return err
+		return file
+	}
+	return rel
+}
+
+// readLink returns the path that the link `link` points to, and if the link
+// is relative. A relative link is resolved from the directory that the link is
+// really in.
+func readLink(link string) (dest string, relative bool, err error) {
+	dest, err = os.Readlink(link)
+	if err != nil || filepath.IsAbs(dest) {
+		return dest, false, err
+	}
+
+	dir := filepath.Dir(link)
+	if realDir, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = realDir
+	}
+	return filepath.Join(dir, dest), true, nil
+}
+
+// sameFilesystem returns if the file `file` is on the same filesystem as the
+// closest existing directory of `target`, which is where `target` would be
+// created. If the system doesn't record filesystems, they're assumed to be the
+// same.
+func sameFilesystem(file, target string) (bool, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return false, err
+	}
+
+	dir := filepath.Dir(target)
+	dirInfo, err := os.Stat(dir)
+	for errors.Is(err, os.ErrNotExist) && filepath.Dir(dir) != dir {
+		dir = filepath.Dir(dir)
+		dirInfo, err = os.Stat(dir)
+	}
+	if err != nil {
+		return false, err
 	}
-	return os.Symlink(file, link)
+
+	dev, ok := fileDevice(info)
+	dirDev, dirOk := fileDevice(dirInfo)
+	return !ok || !dirOk || dev == dirDev, nil
 }
 
-func copyDotfile(existing, newFile string) error {
+// copyDotfile copies the file `existing` to `newFile`, giving the copy the
+// permissions `mode` if it isn't empty and the permissions of `existing`
+// otherwise.
+func copyDotfile(existing, newFile, mode string) error {
 	src, err := os.Open(existing)
 	if err != nil {
 		return err
 	}
 	defer src.Close()
 
-	err = os.MkdirAll(filepath.Dir(newFile), 0777)
+	info, err := src.Stat()
 	if err != nil {
 		return err
 	}
+	perm := info.Mode().Perm()
+	if mode != "" {
+		perm, err = config.ParseMode(mode)
+		if err != nil {
+			return err
+		}
+	}
 
-	dest, err := os.Create(newFile)
+	dest, err := os.OpenFile(
+		newFile,
+		os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
+		perm,
+	)
 	if err != nil {
 		return err
 	}
@@ -170,6 +585,12 @@ func copyDotfile(existing, newFile string) error {
 		return err
 	}
 
+	// The umask may have taken permissions away when the file was created.
+	err = dest.Chmod(perm)
+	if err != nil {
+		return err
+	}
+
 	return dest.Sync()
 }
 
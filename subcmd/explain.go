@@ -0,0 +1,211 @@
+package subcmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aus-hawk/estragon/config"
+)
+
+func (s SubcmdRunner) explainSubcmd(dots []string) error {
+	for i, dot := range dots {
+		err := s.explainDot(dot)
+		if err != nil {
+			return err
+		}
+		if i != len(dots)-1 {
+			fmt.Println()
+		}
+	}
+	return nil
+}
+
+func (s SubcmdRunner) explainDot(dot string) error {
+	e := s.conf.Explain(dot)
+	expand := pathExpander{dot}.expand
+
+	fmt.Println("Dot:", dot)
+	fmt.Println("Source:", s.conf.Source(dot))
+	if len(e.Extends) > 0 {
+		fmt.Println("Extends:", strings.Join(e.Extends, ", "))
+	}
+	reason, _, err := s.skipReason(dot)
+	if err != nil {
+		return err
+	} else if reason != "" {
+		fmt.Println("Skipped because", reason)
+	}
+	fmt.Println("Merge:", e.Merge)
+	fmt.Println()
+
+	printSetting("Method", e.Method)
+
+	expandedRoot, err := expand(e.Root.Value)
+	if err != nil {
+		return err
+	}
+	e.Root.Value += " (expanded to " + expandedRoot + ")"
+	printSetting("Root", e.Root)
+	printSetting("Dot prefix", e.DotPrefix)
+	printSetting("Link style", e.LinkStyle)
+	printSetting("Fold", e.Fold)
+	if e.Config.Mode != "" {
+		fmt.Println("Mode:", e.Config.Mode)
+	}
+	if e.Config.Owner != "" {
+		fmt.Println("Owner:", e.Config.Owner)
+	}
+	if e.Config.Group != "" {
+		fmt.Println("Group:", e.Config.Group)
+	}
+	fmt.Println()
+
+	fmt.Println("Rules:")
+	printKeyed("  ", e.Rules)
+	rules := mapKeys(e.Config.Rules)
+	sort.Strings(rules)
+	for _, k := range rules {
+		fmt.Printf(
+			"    %s -> %s%s (from %q)\n",
+			k,
+			e.Config.Rules[k],
+			describeRuleOptions(e.Config.RuleOptions[k]),
+			e.RuleKeys[k],
+		)
+		for _, t := range e.Config.FanOut[k] {
+			fmt.Printf(
+				"    %s -> %s%s (from %q)\n",
+				k,
+				t.Target,
+				describeRuleOptions(t.RuleOptions),
+				e.RuleKeys[k],
+			)
+		}
+	}
+	fmt.Println()
+
+	fmt.Println("Deploy commands:")
+	printKeyed("  ", e.Deploy)
+	for _, cmd := range e.Config.Deploy {
+		fmt.Println("    " + strings.Join(cmd, " "))
+	}
+	fmt.Println()
+
+	// Configs that don't install packages have neither command.
+	checkCmd, installCmd := s.conf.CheckCmd(), s.conf.InstallCmd()
+	if len(checkCmd) > 0 {
+		fmt.Println("Check command:")
+		printKeyed("  ", e.CheckCmd)
+		fmt.Println("    " + strings.Join(checkCmd, " "))
+	}
+	if len(installCmd) > 0 {
+		fmt.Println("Install command:")
+		printKeyed("  ", e.InstallCmd)
+		fmt.Println("    " + strings.Join(installCmd, " "))
+	}
+	if len(checkCmd) > 0 || len(installCmd) > 0 {
+		fmt.Println()
+	}
+
+	fmt.Println("Packages:")
+	for _, pkg := range e.Packages {
+		fmt.Printf("  %s: %s\n", pkg.Name, pkg.Desc)
+		if pkg.Location == "" {
+			fmt.Println("    Not in the global package map")
+		} else {
+			printKeyed("    ", pkg.KeyedExplanation)
+		}
+		fmt.Println("    Expands to:", strings.Join(pkg.List, " "))
+	}
+	fmt.Println()
+
+	deployer, files, err := dotDeployer(
+		s.conf,
+		s.dir,
+		dot,
+		OwnershipManager{},
+		true,
+	)
+	if err != nil {
+		return err
+	}
+	deployments, err := deployer.resolve(files, e.Config.Rules)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Files (original -> deployed):")
+	if len(deployments) == 0 {
+		fmt.Println("  No files to deploy")
+	}
+	for _, dep := range deployments {
+		o := config.RuleOptions{
+			Mode:  dep.mode,
+			Owner: dep.owner,
+			Group: dep.group,
+		}
+		if dep.method != e.Config.Method {
+			o.Method = dep.method
+		}
+		fmt.Printf(
+			"  %s -> %s%s\n",
+			dep.source,
+			dep.target,
+			describeRuleOptions(o),
+		)
+	}
+
+	return nil
+}
+
+func printSetting(name string, s config.SettingExplanation) {
+	fmt.Printf("%s: %s\n", name, s.Value)
+	if s.Location == "" {
+		fmt.Println("  Default value")
+	} else if s.Choice == nil {
+		fmt.Println("  From", s.Location)
+	} else {
+		fmt.Printf("  From %s%s\n", s.Location, describeFields(*s.Choice))
+	}
+}
+
+func printKeyed(indent string, e config.KeyedExplanation) {
+	if len(e.Chosen) == 0 {
+		fmt.Printf("%sNo key in %s matched\n", indent, e.Location)
+	}
+	for _, c := range e.Chosen {
+		fmt.Printf(
+			"%sFrom %s[%q]%s\n",
+			indent,
+			e.Location,
+			c.Key,
+			describeFields(c),
+		)
+	}
+	for _, c := range e.Lost {
+		fmt.Printf(
+			"%sAlso matched but unused: %q%s\n",
+			indent,
+			c.Key,
+			describeFields(c),
+		)
+	}
+}
+
+func describeFields(c config.Choice) string {
+	if strings.TrimSpace(c.Key) == "" {
+		return " (wildcard)"
+	} else if len(c.Fields) == 0 {
+		return " (matched no fields)"
+	}
+	return " (matched " + strings.Join(c.Fields, " ") + ")"
+}
+
+func mapKeys[K comparable, V any](m map[K]V) []K {
+	ks := make([]K, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
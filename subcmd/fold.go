@@ -0,0 +1,298 @@
+package subcmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// foldDeployments replaces the links to the files of a directory with a single
+// link to the directory, like GNU Stow does, wherever the directory would only
+// hold those links. A directory is folded if every file in it is linked with
+// the deep method under the same name, nothing else is placed in it, and its
+// target doesn't exist or is already a folded directory of the dot. The
+// outermost directories are folded first, up to the dot directory `dotRoot`.
+// `owned` are the files that the dot already owns.
+func foldDeployments(
+	deployments []deployment,
+	dotRoot string,
+	owned []OwnedFile,
+) ([]deployment, error) {
+	ownedFolds := make(map[string]bool)
+	for _, file := range owned {
+		if file.Folded {
+			ownedFolds[file.Target] = true
+		}
+	}
+
+	// The directories the links could be folded into, mapped to the
+	// directories they would link to, or to an empty string if the files in
+	// them come from different directories.
+	candidates := make(map[string]string)
+	for _, dep := range deployments {
+		inRoot := strings.HasPrefix(
+			dep.source,
+			dotRoot+string(filepath.Separator),
+		)
+		if dep.method != "deep" || !inRoot {
+			continue
+		}
+		target, source := dep.target, dep.source
+		for source != dotRoot &&
+			filepath.Base(target) == filepath.Base(source) {
+			target, source = filepath.Dir(target), filepath.Dir(source)
+			if filepath.Dir(target) == target {
+				break
+			}
+			if s, ok := candidates[target]; !ok {
+				candidates[target] = source
+			} else if s != source {
+				candidates[target] = ""
+			}
+		}
+	}
+
+	dirs := mapKeys(candidates)
+	sort.Strings(dirs)
+	folded := make([]deployment, 0)
+	for _, dir := range dirs {
+		if candidates[dir] == "" || within(dir, folded) {
+			continue
+		}
+		fold, ok, err := foldDir(dir, candidates[dir], deployments, ownedFolds)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			folded = append(folded, fold)
+		}
+	}
+	if len(folded) == 0 {
+		return deployments, nil
+	}
+
+	result := folded
+	for _, dep := range deployments {
+		if !within(dep.target, folded) {
+			result = append(result, dep)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.source != b.source {
+			return a.source < b.source
+		}
+		return a.target < b.target
+	})
+	return result, nil
+}
+
+// foldDir returns the deployment that folds the links in the directory `dir` to
+// the files in the directory `source`, and if the links can be folded.
+func foldDir(
+	dir, source string,
+	deployments []deployment,
+	ownedFolds map[string]bool,
+) (deployment, bool, error) {
+	fold := deployment{
+		source: source,
+		target: dir,
+		method: "deep",
+		folded: true,
+	}
+
+	_, err := os.Lstat(dir)
+	if err == nil && !ownedFold(dir, ownedFolds) {
+		return fold, false, nil
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fold, false, err
+	}
+
+	placed := 0
+	for _, dep := range deployments {
+		rel, ok := strings.CutPrefix(dep.target, dir+string(filepath.Separator))
+		if !ok {
+			continue
+		}
+		if dep.method != "deep" || dep.source != filepath.Join(source, rel) {
+			return fold, false, nil
+		}
+		if placed == 0 {
+			fold.mode, fold.owner, fold.group = dep.mode, dep.owner, dep.group
+		} else if fold.mode != dep.mode ||
+			fold.owner != dep.owner ||
+			fold.group != dep.group {
+			return fold, false, nil
+		}
+		placed++
+	}
+
+	// Any file that isn't placed, such as an ignored one, would show up in
+	// the folded directory.
+	files := 0
+	err = filepath.WalkDir(source, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files++
+		}
+		return err
+	})
+	if err != nil {
+		return fold, false, err
+	}
+	return fold, files == placed, nil
+}
+
+// ownedFold returns if `dir` or one of its parents is in `ownedFolds`, in which
+// case whatever is there is owned by the dot.
+func ownedFold(dir string, ownedFolds map[string]bool) bool {
+	for ; filepath.Dir(dir) != dir; dir = filepath.Dir(dir) {
+		if ownedFolds[dir] {
+			return true
+		}
+	}
+	return false
+}
+
+// within returns if `file` is within the target of one of the `deployments`.
+func within(file string, deployments []deployment) bool {
+	for _, dep := range deployments {
+		if strings.HasPrefix(file, dep.target+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// unfoldParents unfolds the folded directories of any dot that the targets of
+// the deployments are in, so that the files can be placed next to the ones
+// that are already there. A folded directory is replaced by a directory with a
+// link to each of the entries of the directory it linked to, where the links to
+// directories are folded in turn, until the targets are no longer in one.
+func (d DotfileDeployer) unfoldParents(deployments []deployment) error {
+	dotOwn, err := d.own.OwnedFiles()
+	if err != nil {
+		return err
+	}
+
+	folds := make(map[string]string)
+	for dot, files := range dotOwn {
+		for _, file := range files {
+			if file.Folded {
+				folds[file.Target] = dot
+			}
+		}
+	}
+
+	unfolded := false
+	for _, dep := range deployments {
+		parents := make([]string, 0)
+		dir := filepath.Dir(dep.target)
+		for ; filepath.Dir(dir) != dir; dir = filepath.Dir(dir) {
+			parents = append(parents, dir)
+		}
+
+		for i := len(parents) - 1; i >= 0; i-- {
+			dot, ok := folds[parents[i]]
+			if !ok {
+				continue
+			}
+			err := d.unfold(parents[i], dot, dotOwn, folds)
+			if err != nil {
+				return err
+			}
+			unfolded = true
+		}
+	}
+
+	if !unfolded || d.dry {
+		return nil
+	}
+	return d.own.write(dotOwn)
+}
+
+// unfold unfolds the folded directory `dir` of the dot `dot`, replacing its
+// record in `dotOwn` with the links in it and updating `folds` to match.
+func (d DotfileDeployer) unfold(
+	dir, dot string,
+	dotOwn map[string][]OwnedFile,
+	folds map[string]string,
+) error {
+	files := dotOwn[dot]
+	index := -1
+	for i, file := range files {
+		if file.Target == dir {
+			index = i
+		}
+	}
+	delete(folds, dir)
+	if index == -1 {
+		// The dot no longer owns the directory, so it isn't folded.
+		return nil
+	}
+	fold := files[index]
+
+	entries, err := os.ReadDir(fold.Source)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Unfolding", dir, "owned by", dot)
+	if !d.dry {
+		_, relative, err := readLink(dir)
+		if err != nil {
+			return err
+		}
+		style := "absolute"
+		if relative {
+			style = "relative"
+		}
+
+		err = os.Remove(dir)
+		if err != nil {
+			return err
+		}
+		err = os.Mkdir(dir, 0777)
+		if err != nil {
+			return err
+		}
+		root := os.Geteuid() == 0
+		if root {
+			err = chown(dir, fold.Owner, fold.Group)
+			if err != nil {
+				return err
+			}
+		}
+		for _, e := range entries {
+			source := filepath.Join(fold.Source, e.Name())
+			link := filepath.Join(dir, e.Name())
+			err = os.Symlink(linkDestination(source, link, style), link)
+			if err != nil {
+				return err
+			}
+			if root {
+				err = chown(link, fold.Owner, fold.Group)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	unfolded := make([]OwnedFile, 0, len(files)+len(entries))
+	unfolded = append(unfolded, files[:index]...)
+	for _, e := range entries {
+		file := fold
+		file.Target = filepath.Join(dir, e.Name())
+		file.Source = filepath.Join(fold.Source, e.Name())
+		file.Folded = e.IsDir()
+		unfolded = append(unfolded, file)
+		if file.Folded {
+			folds[file.Target] = dot
+		}
+	}
+	dotOwn[dot] = append(unfolded, files[index+1:]...)
+	return nil
+}
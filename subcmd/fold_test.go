@@ -0,0 +1,284 @@
+package subcmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// makeFiles creates the `files` within `dir` along with their directories.
+func makeFiles(t *testing.T, dir string, files ...string) {
+	t.Helper()
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		err := os.MkdirAll(filepath.Dir(path), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(file), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// deepDeployments returns deep deployments of the `files` within `source` to
+// the same places within `target`.
+func deepDeployments(source, target string, files ...string) []deployment {
+	deployments := make([]deployment, 0, len(files))
+	for _, file := range files {
+		deployments = append(deployments, deployment{
+			source: filepath.Join(source, file),
+			target: filepath.Join(target, file),
+			method: "deep",
+		})
+	}
+	return deployments
+}
+
+func TestFoldDeployments(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "dots", "nvim")
+	home := filepath.Join(dir, "home", "nvim")
+	makeFiles(t, src, "init.lua", "lua/a.lua", "lua/b.lua")
+
+	all := deepDeployments(src, home, "init.lua", "lua/a.lua", "lua/b.lua")
+	copied := deepDeployments(src, home, "init.lua", "lua/a.lua", "lua/b.lua")
+	copied[0].method = "copy"
+
+	tests := []struct {
+		desc        string
+		deployments []deployment
+		expected    []deployment
+	}{
+		{
+			"Every file is placed",
+			all,
+			[]deployment{{
+				source: src,
+				target: home,
+				method: "deep",
+				folded: true,
+			}},
+		},
+		{
+			"A file that isn't placed keeps its directory unfolded",
+			all[1:],
+			[]deployment{{
+				source: filepath.Join(src, "lua"),
+				target: filepath.Join(home, "lua"),
+				method: "deep",
+				folded: true,
+			}},
+		},
+		{
+			"A copied file keeps its directory unfolded",
+			copied,
+			[]deployment{
+				copied[0],
+				{
+					source: filepath.Join(src, "lua"),
+					target: filepath.Join(home, "lua"),
+					method: "deep",
+					folded: true,
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			actual, err := foldDeployments(test.deployments, src, nil)
+			if err != nil {
+				t.Fatal("expected err to be nil, was " + err.Error())
+			}
+			if !reflect.DeepEqual(test.expected, actual) {
+				t.Errorf("expected %#v, got %#v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFoldDir(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "dots", "nvim")
+	home := filepath.Join(dir, "home", "nvim")
+	existing := filepath.Join(dir, "home", "existing")
+	makeFiles(t, src, "a", "b")
+	makeFiles(t, existing, "unowned")
+
+	deployments := deepDeployments(src, home, "a", "b")
+	modes := deepDeployments(src, home, "a", "b")
+	modes[0].mode = "0600"
+
+	tests := []struct {
+		desc        string
+		dir         string
+		deployments []deployment
+		ownedFolds  map[string]bool
+		ok          bool
+	}{
+		{"Missing directory", home, deployments, nil, true},
+		{"Different modes", home, modes, nil, false},
+		{"Existing directory", existing, deployments, nil, false},
+		{
+			"Existing owned fold",
+			existing,
+			deepDeployments(src, existing, "a", "b"),
+			map[string]bool{existing: true},
+			true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			_, ok, err := foldDir(
+				test.dir,
+				src,
+				test.deployments,
+				test.ownedFolds,
+			)
+			if err != nil {
+				t.Fatal("expected err to be nil, was " + err.Error())
+			}
+			if ok != test.ok {
+				t.Errorf("expected ok to be %v, got %v", test.ok, ok)
+			}
+		})
+	}
+}
+
+// foldedDeployer returns a deployer whose ownership file records that the dot
+// "nvim" folded the directory `home` from `src`, which is linked on disk.
+func foldedDeployer(t *testing.T, dir, src, home string) DotfileDeployer {
+	t.Helper()
+	err := os.MkdirAll(filepath.Dir(home), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(src, home)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	own := OwnershipManager{filepath.Join(dir, "own.json"), false}
+	err = own.write(map[string][]OwnedFile{
+		"nvim": {{Target: home, Source: src, Folded: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return DotfileDeployer{own: own}
+}
+
+func TestUnfoldParents(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "dots", "nvim")
+	home := filepath.Join(dir, "home", "nvim")
+	makeFiles(t, src, "init.lua", "lua/a.lua")
+	d := foldedDeployer(t, dir, src, home)
+
+	extra := deployment{
+		source: filepath.Join(dir, "dots", "extra", "lua", "extra.lua"),
+		target: filepath.Join(home, "lua", "extra.lua"),
+		method: "deep",
+	}
+	err := d.unfoldParents([]deployment{extra})
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	for _, unfolded := range []string{home, filepath.Join(home, "lua")} {
+		info, err := os.Lstat(unfolded)
+		if err != nil {
+			t.Fatal(err)
+		} else if !info.IsDir() {
+			t.Errorf("expected %s to be a directory", unfolded)
+		}
+	}
+	dest, err := os.Readlink(filepath.Join(home, "lua", "a.lua"))
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := filepath.Join(src, "lua", "a.lua"); dest != expected {
+		t.Errorf("expected link to %s, got %s", expected, dest)
+	}
+
+	dotOwn, err := d.own.OwnedFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]OwnedFile{
+		"nvim": {
+			{
+				Target: filepath.Join(home, "init.lua"),
+				Source: filepath.Join(src, "init.lua"),
+			},
+			{
+				Target: filepath.Join(home, "lua", "a.lua"),
+				Source: filepath.Join(src, "lua", "a.lua"),
+			},
+		},
+	}
+	if !reflect.DeepEqual(expected, dotOwn) {
+		t.Errorf("expected %#v, got %#v", expected, dotOwn)
+	}
+}
+
+func TestUnfoldParentsDry(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "dots", "nvim")
+	home := filepath.Join(dir, "home", "nvim")
+	makeFiles(t, src, "init.lua")
+	d := foldedDeployer(t, dir, src, home)
+	d.dry = true
+
+	err := d.unfoldParents(deepDeployments(src, home, "new.lua"))
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+
+	info, err := os.Lstat(home)
+	if err != nil {
+		t.Fatal(err)
+	} else if info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected %s to still be a link", home)
+	}
+}
+
+func TestUnfoldUnowned(t *testing.T) {
+	dir := t.TempDir()
+	home := filepath.Join(dir, "home", "nvim")
+	dotOwn := map[string][]OwnedFile{"nvim": nil}
+	folds := map[string]string{home: "nvim"}
+
+	err := DotfileDeployer{}.unfold(home, "nvim", dotOwn, folds)
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+	if len(folds) != 0 {
+		t.Errorf("expected no folds, got %#v", folds)
+	}
+}
+
+func TestEnsureOwnershipInsideFold(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "dots", "nvim")
+	home := filepath.Join(dir, "home", "nvim")
+	makeFiles(t, src, "init.lua")
+	d := foldedDeployer(t, dir, src, home)
+
+	target := filepath.Join(home, "init.lua")
+	own := OwnershipManager{d.own.ownJson, true}
+	files := []OwnedFile{{Target: target, Source: "other"}}
+
+	err := own.CheckOwnership(files, "extra")
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+	err = own.EnsureOwnership(files, "extra")
+	if err == nil {
+		t.Error("expected err to be non-nil, was nil")
+	}
+	if _, err := os.Stat(filepath.Join(src, "init.lua")); err != nil {
+		t.Error("expected the folded file to remain, got " + err.Error())
+	}
+}
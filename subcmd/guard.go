@@ -0,0 +1,50 @@
+package subcmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// unmetGuard returns why one of the guards of the dot `dot` isn't met, which is
+// a command that isn't installed or a path that doesn't exist. If every guard
+// is met, the reason is empty.
+func (s SubcmdRunner) unmetGuard(dot string) (string, error) {
+	cmds, paths := s.conf.Guards(dot)
+	for _, cmd := range cmds {
+		if _, err := exec.LookPath(cmd); err != nil {
+			return fmt.Sprintf("the command %q is not installed", cmd), nil
+		}
+	}
+
+	expand := pathExpander{dot}.expand
+	for _, path := range paths {
+		expanded, err := expand(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = os.Stat(expanded)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Sprintf("the path %q does not exist", path), nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// skipReason returns why the dot `dot` is skipped when installing or
+// deploying, either because it doesn't apply to the environment or because
+// one of its guards isn't met, in which case guarded is true. If the dot isn't
+// skipped, the reason is empty.
+func (s SubcmdRunner) skipReason(
+	dot string,
+) (reason string, guarded bool, err error) {
+	if ok, reason := s.conf.Applies(dot); !ok {
+		return reason, false, nil
+	}
+	reason, err = s.unmetGuard(dot)
+	return reason, reason != "", err
+}
@@ -0,0 +1,50 @@
+package subcmd
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aus-hawk/estragon/config"
+	"github.com/aus-hawk/estragon/dotfile"
+)
+
+// dotIgnorer returns the Ignorer of the dot `dot` in the config directory
+// `dir`, which uses the ignore patterns in the config followed by the ones in
+// the .estragonignore files among its `files`. The sources map the files that
+// come from an overlay directory to where they are.
+func dotIgnorer(
+	conf config.Config,
+	dir, dot string,
+	files []string,
+	sources map[string]string,
+) (dotfile.Ignorer, error) {
+	ignorer, err := dotfile.Ignorer{}.With("", conf.Ignore(dot))
+	if err != nil {
+		return ignorer, err
+	}
+
+	root := dotDir(conf, dir, dot)
+	for _, file := range files {
+		if path.Base(file) != dotfile.IgnoreFile {
+			continue
+		}
+
+		src, ok := sources[file]
+		if !ok {
+			src = filepath.Join(root, filepath.FromSlash(file))
+		}
+		content, err := os.ReadFile(src)
+		if err != nil {
+			return ignorer, err
+		}
+
+		lines := strings.Split(string(content), "\n")
+		ignorer, err = ignorer.With(path.Dir(file), lines)
+		if err != nil {
+			return ignorer, err
+		}
+	}
+	return ignorer, nil
+}
@@ -0,0 +1,39 @@
+package subcmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lsSubcmd prints the `dots` with their tags in the order that they would be
+// deployed, along with the dots that they require which weren't selected and
+// why any of them would be skipped.
+func (s SubcmdRunner) lsSubcmd(dots []string) error {
+	ordered, err := s.conf.DependencyOrder(dots)
+	if err != nil {
+		return err
+	}
+
+	selected := make(map[string]bool)
+	for _, dot := range dots {
+		selected[dot] = true
+	}
+
+	for _, dot := range ordered {
+		line := dot
+		if tags := s.conf.Tags(dot); len(tags) > 0 {
+			line += " [" + strings.Join(tags, ", ") + "]"
+		}
+		if !selected[dot] {
+			line += " (required)"
+		}
+		reason, _, err := s.skipReason(dot)
+		if err != nil {
+			return err
+		} else if reason != "" {
+			line += " (skipped because " + reason + ")"
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
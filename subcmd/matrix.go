@@ -0,0 +1,157 @@
+package subcmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aus-hawk/estragon/env"
+)
+
+// Matrix prints what each of the dots would do under each of the environments
+// in `envs` side by side, without changing the system. An environment can be
+// the name of a profile in the config, and if `envs` is empty, every profile is
+// used. Rows that differ between environments are marked with an asterisk, and
+// if diff is true, only those rows are printed.
+func (s SubcmdRunner) Matrix(envs []string, dots []string, diff bool) error {
+	err := s.setEnvvars()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	names, envStrings, err := s.matrixEnvs(envs)
+	if err != nil {
+		return err
+	}
+
+	for i, dot := range dots {
+		rows := newMatrixRows(len(envStrings))
+		for j, envString := range envStrings {
+			conf := s.conf.WithSelector(env.NewEnvironment(envString))
+
+			if err := conf.ValidateEnv(); err != nil {
+				rows.set("validation", j, err.Error())
+			} else {
+				rows.set("validation", j, "ok")
+			}
+
+			if ok, reason := conf.Applies(dot); ok {
+				rows.set("applies", j, "yes")
+			} else {
+				rows.set("applies", j, "no, "+reason)
+			}
+
+			plan, err := planDot(conf, s.dir, dot)
+			if err != nil {
+				rows.set("error", j, err.Error())
+				continue
+			}
+			rows.addPlan(j, plan)
+		}
+
+		fmt.Printf("Dot %s:\n", dot)
+		rows.print(names, diff)
+		if i != len(dots)-1 {
+			fmt.Println()
+		}
+	}
+
+	return nil
+}
+
+// matrixEnvs returns the column names and environment strings of the matrix.
+// Environments that are the names of profiles are replaced with the profile.
+func (s SubcmdRunner) matrixEnvs(envs []string) ([]string, []string, error) {
+	profiles := s.conf.Profiles()
+	if len(envs) == 0 {
+		if len(profiles) == 0 {
+			return nil, nil, errors.New(
+				"No --env arguments or profiles in config",
+			)
+		}
+		for name := range profiles {
+			envs = append(envs, name)
+		}
+		sort.Strings(envs)
+	}
+
+	names := make([]string, 0, len(envs))
+	envStrings := make([]string, 0, len(envs))
+	for _, e := range envs {
+		if profile, ok := profiles[e]; ok {
+			names = append(names, e+" ("+profile+")")
+			envStrings = append(envStrings, profile)
+		} else {
+			names = append(names, e)
+			envStrings = append(envStrings, e)
+		}
+	}
+	return names, envStrings, nil
+}
+
+// matrixRows is a table of labeled rows with a value for each environment,
+// kept in the order the labels were first added.
+type matrixRows struct {
+	width  int
+	labels []string
+	values map[string][]string
+}
+
+func newMatrixRows(width int) matrixRows {
+	return matrixRows{width, nil, make(map[string][]string)}
+}
+
+func (m *matrixRows) set(label string, col int, value string) {
+	row, ok := m.values[label]
+	if !ok {
+		row = make([]string, m.width)
+		for i := range row {
+			row[i] = "-"
+		}
+		m.labels = append(m.labels, label)
+		m.values[label] = row
+	}
+	row[col] = value
+}
+
+func (m *matrixRows) addPlan(col int, p dotPlan) {
+	m.set("method", col, p.method)
+	m.set("root", col, p.root)
+	m.set("dot prefix", col, fmt.Sprint(p.dotPrefix))
+
+	pkgs := mapKeys(p.packages)
+	sort.Strings(pkgs)
+	for _, pkg := range pkgs {
+		m.set("package "+pkg, col, strings.Join(p.packages[pkg], " "))
+	}
+
+	files := mapKeys(p.files)
+	sort.Strings(files)
+	for _, file := range files {
+		m.set("file "+file, col, p.files[file])
+	}
+
+	m.set("deploy", col, strings.Join(p.deploy, "; "))
+}
+
+func (m matrixRows) print(names []string, diff bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "   \t%s\n", strings.Join(names, "\t"))
+	for _, label := range m.labels {
+		row := m.values[label]
+		differs := false
+		for _, v := range row {
+			differs = differs || v != row[0]
+		}
+
+		if differs {
+			fmt.Fprintf(w, " * %s\t%s\n", label, strings.Join(row, "\t"))
+		} else if !diff {
+			fmt.Fprintf(w, "   %s\t%s\n", label, strings.Join(row, "\t"))
+		}
+	}
+	w.Flush()
+}
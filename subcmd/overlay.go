@@ -0,0 +1,76 @@
+package subcmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aus-hawk/estragon/config"
+)
+
+// dotLayers returns the directories whose files make up the dot `dot` in the
+// config directory `dir`: its source directory, followed by the overlay
+// directories next to it that apply to the environment, from least to most
+// specific. An overlay directory is named after the source directory followed
+// by an @ and an environment key, like nvim@work.
+func dotLayers(conf config.Config, dir, dot string) ([]string, error) {
+	base := dotDir(conf, dir, dot)
+	entries, err := os.ReadDir(filepath.Dir(base))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{base}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(base) + "@"
+	keys := make([]string, 0)
+	for _, e := range entries {
+		if key, ok := strings.CutPrefix(e.Name(), prefix); ok && e.IsDir() {
+			keys = append(keys, key)
+		}
+	}
+
+	layers := []string{base}
+	for _, key := range conf.Overlays(keys) {
+		layers = append(layers, base+"@"+key)
+	}
+	return layers, nil
+}
+
+// dotFiles returns the files of the dot `dot` relative to its source
+// directory, merged file by file from its layers with later layers overriding
+// earlier ones. The sources map the files that come from an overlay directory
+// to where they are. If none of the layers exist, the files are nil.
+func dotFiles(
+	conf config.Config,
+	dir, dot string,
+) (files []string, sources map[string]string, err error) {
+	layers, err := dotLayers(conf, dir, dot)
+	if err != nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	sources = make(map[string]string)
+	for i, layer := range layers {
+		layerFiles, err := dirFiles(layer)
+		if err != nil {
+			return nil, nil, err
+		} else if layerFiles != nil && files == nil {
+			files = make([]string, 0)
+		}
+
+		for _, file := range layerFiles {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+			if i > 0 {
+				sources[file] = filepath.Join(layer, filepath.FromSlash(file))
+			}
+		}
+	}
+
+	return
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"path/filepath"
+	"sort"
 )
 
 type OwnershipManager struct {
@@ -11,64 +13,97 @@ type OwnershipManager struct {
 	force   bool
 }
 
-// takeOwnership takes ownership of the
-func (o OwnershipManager) EnsureOwnership(files []string, dot string) error {
-	data, err := os.ReadFile(o.ownJson)
-	if err != nil {
-		return err
+// An OwnedFile is a file deployed by a dot, along with the file it was deployed
+// from. The source tells apart the layers a dot's files can come from. The
+// mode, owner, and group are the ones the file was given, if they were set.
+// Hardlink is whether the file is a hard link to its source, and Folded is
+// whether it is a link to a directory whose files would otherwise be linked one
+// by one.
+type OwnedFile struct {
+	Target   string `json:"target"`
+	Source   string `json:"source,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Hardlink bool   `json:"hardlink,omitempty"`
+	Folded   bool   `json:"folded,omitempty"`
+}
+
+// UnmarshalJSON reads an owned file from either an object or a string of just
+// the target, which is how older versions recorded owned files.
+func (f *OwnedFile) UnmarshalJSON(data []byte) error {
+	var target string
+	if err := json.Unmarshal(data, &target); err == nil {
+		*f = OwnedFile{Target: target}
+		return nil
 	}
 
-	var dotOwn map[string][]string
-	err = json.Unmarshal(data, &dotOwn)
+	type ownedFile OwnedFile
+	return json.Unmarshal(data, (*ownedFile)(f))
+}
+
+// EnsureOwnership takes ownership of the `files` being deployed for the dot
+// `dot`.
+func (o OwnershipManager) EnsureOwnership(
+	files []OwnedFile,
+	dot string,
+) error {
+	dotOwn, err := o.OwnedFiles()
 	if err != nil {
 		return err
 	}
 
-	dotOwn[dot], err = o.ensureOwnershipDot(files, dotOwn[dot])
+	dotOwn[dot], err = o.ensureOwnershipDot(files, dotOwn, dot, false)
 	if err != nil {
 		return err
 	}
 
-	data, err = json.Marshal(dotOwn)
+	return o.write(dotOwn)
+}
+
+// CheckOwnership checks that the dot `dot` could take ownership of the `files`
+// without changing anything, for dry runs.
+func (o OwnershipManager) CheckOwnership(files []OwnedFile, dot string) error {
+	dotOwn, err := o.OwnedFiles()
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(o.ownJson, data, 0666)
-
+	_, err = o.ensureOwnershipDot(files, dotOwn, dot, true)
 	return err
 }
 
-func (o OwnershipManager) OwnedFiles() (map[string][]string, error) {
+func (o OwnershipManager) OwnedFiles() (map[string][]OwnedFile, error) {
 	data, err := os.ReadFile(o.ownJson)
 	if err != nil {
 		return nil, err
 	}
 
-	var dotOwn map[string][]string
+	var dotOwn map[string][]OwnedFile
 	err = json.Unmarshal(data, &dotOwn)
 	if err != nil {
 		return nil, err
 	}
+	if dotOwn == nil {
+		dotOwn = make(map[string][]OwnedFile)
+	}
 
 	return dotOwn, nil
 }
 
 func (o OwnershipManager) DisownDot(dot string) error {
-	data, err := os.ReadFile(o.ownJson)
-	if err != nil {
-		return err
-	}
-
-	var dotOwn map[string][]string
-	err = json.Unmarshal(data, &dotOwn)
+	dotOwn, err := o.OwnedFiles()
 	if err != nil {
 		return err
 	}
 
 	delete(dotOwn, dot)
 
-	data, err = json.Marshal(dotOwn)
+	return o.write(dotOwn)
+}
+
+func (o OwnershipManager) write(dotOwn map[string][]OwnedFile) error {
+	data, err := json.Marshal(dotOwn)
 	if err != nil {
 		return err
 	}
@@ -78,25 +113,57 @@ func (o OwnershipManager) DisownDot(dot string) error {
 
 // ensureOwnershipDot checks that the current directory owns the dots it is
 // trying to possess, or take them by force if that's allowed. It returns a new
-// list of the owned files and an error that is non-nil if something goes wrong.
+// list of the owned files of the dot `dot` and an error that is non-nil if
+// something goes wrong, including when an owned hard link was replaced since it
+// was deployed and isn't forced. If `dry` is true, nothing is removed.
 func (o OwnershipManager) ensureOwnershipDot(
-	files []string,
-	ownedFiles []string,
-) ([]string, error) {
-	ownedFileSet := make(map[string]struct{})
-	for _, file := range ownedFiles {
-		ownedFileSet[file] = struct{}{}
+	files []OwnedFile,
+	dotOwn map[string][]OwnedFile,
+	dot string,
+	dry bool,
+) ([]OwnedFile, error) {
+	ownedFiles := append([]OwnedFile(nil), dotOwn[dot]...)
+	ownedFileIndex := make(map[string]int)
+	for i, file := range ownedFiles {
+		ownedFileIndex[file.Target] = i
 	}
 
+	folds := make(map[string]bool)
+	for _, owned := range dotOwn {
+		for _, file := range owned {
+			if file.Folded {
+				folds[file.Target] = true
+			}
+		}
+	}
+
+	files = append([]OwnedFile(nil), files...)
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Target < files[j].Target
+	})
+
 	for _, file := range files {
-		_, owned := ownedFileSet[file]
+		i, owned := ownedFileIndex[file.Target]
+
+		if owned && ownedFiles[i].Hardlink && !o.force &&
+			!sharesInode(ownedFiles[i]) {
+			// Undeploying keeps these for the same reason.
+			return nil, errors.New(
+				file.Target + " is no longer hard linked to " +
+					ownedFiles[i].Source + " and may hold changes, " +
+					"so it is only replaced by force",
+			)
+		}
 
-		err := o.ensureOwnershipFile(file, owned)
+		err := o.ensureOwnershipFile(file.Target, owned, folds, dry)
 		if err != nil {
 			return nil, err
 		}
 
-		if !owned {
+		if owned {
+			// The source can change, such as when an overlay applies.
+			ownedFiles[i] = file
+		} else {
 			ownedFiles = append(ownedFiles, file)
 		}
 	}
@@ -105,12 +172,20 @@ func (o OwnershipManager) ensureOwnershipDot(
 }
 
 // ensureOwnershipFile ensures ownership of a single file. It does this by
-// deleting the file if it is owned or forced. If something goes wrong doing so,
-// or a file is not owned and ownership cannot be forced, a non-nil error is
-// returned.
-func (o OwnershipManager) ensureOwnershipFile(file string, owned bool) error {
+// deleting the file if it is owned or forced, unless `dry` is true. If
+// something goes wrong doing so, a file is not owned and ownership cannot be
+// forced, or the file is inside one of the folded directories `folds`, a
+// non-nil error is returned.
+func (o OwnershipManager) ensureOwnershipFile(
+	file string,
+	owned bool,
+	folds map[string]bool,
+	dry bool,
+) error {
+	// Only the file itself is looked at, so that a link is never followed
+	// into the files it links to.
 	var fileExists bool
-	if _, err := os.Stat(file); err == nil {
+	if _, err := os.Lstat(file); err == nil {
 		fileExists = true
 	} else if errors.Is(err, os.ErrNotExist) {
 		fileExists = false
@@ -118,9 +193,24 @@ func (o OwnershipManager) ensureOwnershipFile(file string, owned bool) error {
 		return err
 	}
 
+	// Removing a file inside a folded directory would remove it from the
+	// dot it is folded from. A dry run doesn't unfold the directories first.
+	dir := filepath.Dir(file)
+	for fileExists && !dry && filepath.Dir(dir) != dir {
+		if folds[dir] {
+			return errors.New(
+				file + " is inside the folded directory " + dir,
+			)
+		}
+		dir = filepath.Dir(dir)
+	}
+
 	if !fileExists {
 		return nil
 	} else if owned || o.force {
+		if dry {
+			return nil
+		}
 		return os.RemoveAll(file)
 	} else {
 		return errors.New(
@@ -0,0 +1,49 @@
+package subcmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureOwnershipReplacedHardlink(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "dots", "f")
+	target := filepath.Join(dir, "home", "f")
+	makeFiles(t, dir, filepath.Join("dots", "f"), filepath.Join("home", "f"))
+
+	file := OwnedFile{Target: target, Source: source, Hardlink: true}
+	data, err := json.Marshal(map[string][]OwnedFile{"d": {file}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownJson := filepath.Join(dir, "own.json")
+	err = os.WriteFile(ownJson, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The target is a separate file, like one an editor saved over.
+	err = OwnershipManager{ownJson, false}.EnsureOwnership(
+		[]OwnedFile{file},
+		"d",
+	)
+	if err == nil {
+		t.Error("expected err to be non-nil, was nil")
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Error("expected the replaced file to remain, got " + err.Error())
+	}
+
+	err = OwnershipManager{ownJson, true}.EnsureOwnership(
+		[]OwnedFile{file},
+		"d",
+	)
+	if err != nil {
+		t.Fatal("expected err to be nil, was " + err.Error())
+	}
+	if _, err := os.Lstat(target); err == nil {
+		t.Error("expected the forced file to be removed")
+	}
+}
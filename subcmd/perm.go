@@ -0,0 +1,179 @@
+package subcmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/aus-hawk/estragon/config"
+)
+
+// makeParents creates the missing parent directories of the target of `dep`,
+// giving them its mode with an execute bit for every read bit so that they can
+// be entered, along with its owner and group.
+func makeParents(dep deployment) error {
+	missing := make([]string, 0)
+	for dir := filepath.Dir(dep.target); ; dir = filepath.Dir(dir) {
+		_, err := os.Lstat(dir)
+		if err == nil {
+			break
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		missing = append(missing, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	err := os.MkdirAll(filepath.Dir(dep.target), 0777)
+	if err != nil {
+		return err
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		if dep.mode != "" {
+			perm, err := config.ParseMode(dep.mode)
+			if err != nil {
+				return err
+			}
+			err = os.Chmod(missing[i], perm|(perm&0444)>>2)
+			if err != nil {
+				return err
+			}
+		}
+		err = chown(missing[i], dep.owner, dep.group)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chown changes the user and group that own `file` to `owner` and `group`,
+// leaving the ones that are empty as they are. Symlinks are changed themselves
+// instead of the files they link to.
+func chown(file, owner, group string) error {
+	if owner == "" && group == "" {
+		return nil
+	}
+
+	uid, gid := -1, -1
+	if owner != "" {
+		id, err := lookupUser(owner)
+		if err != nil {
+			return err
+		}
+		uid, _ = strconv.Atoi(id)
+	}
+	if group != "" {
+		id, err := lookupGroup(group)
+		if err != nil {
+			return err
+		}
+		gid, _ = strconv.Atoi(id)
+	}
+	return os.Lchown(file, uid, gid)
+}
+
+// lookupUser returns the ID of the user with the name or ID `name`.
+func lookupUser(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u.Uid, nil
+	} else if u, idErr := user.LookupId(name); idErr == nil {
+		return u.Uid, nil
+	}
+	return "", err
+}
+
+// lookupGroup returns the ID of the group with the name or ID `name`.
+func lookupGroup(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err == nil {
+		return g.Gid, nil
+	} else if g, idErr := user.LookupGroupId(name); idErr == nil {
+		return g.Gid, nil
+	}
+	return "", err
+}
+
+// permMismatches describes how the mode, owner, and group of the owned file
+// `file` differ from what it was given when it was deployed. A copy without a
+// mode of its own is expected to have the mode of its source.
+func permMismatches(file OwnedFile) ([]string, error) {
+	info, err := os.Lstat(file.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := make([]string, 0)
+	if info.Mode().IsRegular() {
+		var expected fs.FileMode
+		hasExpected := false
+		if file.Mode != "" {
+			expected, err = config.ParseMode(file.Mode)
+			if err != nil {
+				return nil, err
+			}
+			hasExpected = true
+		} else if file.Source != "" {
+			source, err := os.Stat(file.Source)
+			if err == nil {
+				expected, hasExpected = source.Mode().Perm(), true
+			}
+		}
+
+		if actual := info.Mode().Perm(); hasExpected && actual != expected {
+			mismatches = append(mismatches, fmt.Sprintf(
+				"mode %04o instead of %04o",
+				actual,
+				expected,
+			))
+		}
+	}
+
+	uid, gid, ok := fileOwner(info)
+	if !ok {
+		return mismatches, nil
+	}
+	if file.Owner != "" {
+		expected, err := lookupUser(file.Owner)
+		if err != nil {
+			return nil, err
+		}
+		if uid != expected {
+			name := uid
+			if u, err := user.LookupId(uid); err == nil {
+				name = u.Username
+			}
+			mismatches = append(mismatches, fmt.Sprintf(
+				"owned by %s instead of %s",
+				name,
+				file.Owner,
+			))
+		}
+	}
+	if file.Group != "" {
+		expected, err := lookupGroup(file.Group)
+		if err != nil {
+			return nil, err
+		}
+		if gid != expected {
+			name := gid
+			if g, err := user.LookupGroupId(gid); err == nil {
+				name = g.Name
+			}
+			mismatches = append(mismatches, fmt.Sprintf(
+				"in group %s instead of %s",
+				name,
+				file.Group,
+			))
+		}
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,101 @@
+package subcmd
+
+import (
+	"strings"
+
+	"github.com/aus-hawk/estragon/config"
+)
+
+// A dotPlan is everything that deploying and installing a dot would do under
+// a config, resolved without changing the system. The files map the paths of
+// the files relative to the dot directory to where they would be deployed,
+// with the targets of a file deployed to several places sorted and separated
+// by commas.
+type dotPlan struct {
+	method    string
+	root      string
+	dotPrefix bool
+	packages  map[string][]string
+	files     map[string]string
+	deploy    []string
+}
+
+// planDot resolves what deploying and installing the dot `dot` in the
+// directory `dir` would do under the config `conf`.
+func planDot(conf config.Config, dir, dot string) (p dotPlan, err error) {
+	dotConf := conf.DotConfig(dot)
+	expand := pathExpander{dot}.expand
+
+	p.method = dotConf.Method
+	p.dotPrefix = dotConf.DotPrefix
+	p.root, err = expand(dotConf.Root)
+	if err != nil {
+		return
+	}
+
+	pkgs, err := conf.Packages(dot)
+	if err != nil {
+		return
+	}
+	p.packages = make(map[string][]string)
+	for _, pkg := range pkgs {
+		p.packages[pkg.Name] = pkg.List
+	}
+
+	deployer, files, err := dotDeployer(
+		conf,
+		dir,
+		dot,
+		OwnershipManager{},
+		true,
+	)
+	if err != nil {
+		return
+	}
+	deployments, err := deployer.resolve(files, dotConf.Rules)
+	if err != nil {
+		return
+	}
+
+	p.files = make(map[string]string)
+	for _, dep := range deployments {
+		// Alternates and overlays are planned under the name of the file
+		// they replace.
+		relFile := dep.file
+		if relFile == "" {
+			relFile = "."
+		}
+
+		if target, ok := p.files[relFile]; ok {
+			p.files[relFile] = target + ", " + dep.target
+		} else {
+			p.files[relFile] = dep.target
+		}
+	}
+
+	for _, cmd := range dotConf.Deploy {
+		expandedCmd, err := expandArgs(cmd, expand)
+		if err != nil {
+			return p, err
+		}
+		p.deploy = append(p.deploy, strings.Join(expandedCmd, " "))
+	}
+
+	return
+}
+
+// expandArgs expands each of the arguments `args` of a command with `expand`.
+func expandArgs(
+	args []string,
+	expand func(string) (string, error),
+) ([]string, error) {
+	expanded := make([]string, 0, len(args))
+	for _, arg := range args {
+		arg, err := expand(arg)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, arg)
+	}
+	return expanded, nil
+}
@@ -0,0 +1,187 @@
+package subcmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A relocation is an owned link that is pointed somewhere else, along with the
+// style of link it is.
+type relocation struct {
+	file  OwnedFile
+	dest  string
+	style string
+}
+
+// relocateSubcmd updates the owned links that point into the directory the
+// dots were moved from to point into the current directory instead, along with
+// the sources of the owned files. The old directory is the one in `args` if
+// there is one, and the one recorded in .estragon/dir otherwise.
+func (s SubcmdRunner) relocateSubcmd(args []string) error {
+	var oldDir string
+	var err error
+	switch len(args) {
+	case 0:
+		oldDir, err = s.recordedDir()
+	case 1:
+		oldDir, err = filepath.Abs(args[0])
+	default:
+		err = errors.New("relocate takes at most one directory")
+	}
+	if err != nil {
+		return err
+	}
+
+	if oldDir == s.dir {
+		fmt.Println("The directory is still at", s.dir)
+		return nil
+	}
+	fmt.Printf("Relocating from %s to %s\n\n", oldDir, s.dir)
+
+	ownJson := filepath.Join(s.dir, ".estragon", "own.json")
+	own := OwnershipManager{ownJson, false}
+	dotOwn, err := own.OwnedFiles()
+	if err != nil {
+		return err
+	}
+
+	dots := mapKeys(dotOwn)
+	sort.Strings(dots)
+	relocations := make([]relocation, 0)
+	sources := 0
+	for _, dot := range dots {
+		for i, file := range dotOwn[dot] {
+			r, ok, err := relocateLink(file, oldDir, s.dir)
+			if err != nil {
+				return err
+			} else if ok {
+				relocations = append(relocations, r)
+			}
+
+			if source, ok := relocated(file.Source, oldDir, s.dir); ok {
+				dotOwn[dot][i].Source = source
+				sources++
+			}
+		}
+	}
+
+	if len(relocations) == 0 {
+		fmt.Println("No symlinks point into", oldDir)
+	} else {
+		fmt.Println("Updating the following symlinks (link -> original):")
+	}
+	root := os.Geteuid() == 0
+	for _, r := range relocations {
+		fmt.Printf("  %s -> %s\n", r.file.Target, r.dest)
+		if !s.dry {
+			err := r.relink(root)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("Updating the sources of %d owned files\n", sources)
+
+	if s.dry {
+		return nil
+	}
+	err = own.write(dotOwn)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.dirFile(), []byte(s.dir), 0666)
+}
+
+// relocateLink returns how the owned file `file` is relocated from `oldDir` to
+// `newDir`, and if it is relocated at all, which is only the case for a link
+// that points into `oldDir`.
+func relocateLink(
+	file OwnedFile,
+	oldDir, newDir string,
+) (relocation, bool, error) {
+	r := relocation{file: file, style: "absolute"}
+	info, err := os.Lstat(file.Target)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, false, nil
+	} else if err != nil {
+		return r, false, err
+	} else if info.Mode()&os.ModeSymlink == 0 {
+		return r, false, nil
+	}
+
+	dest, relative, err := readLink(file.Target)
+	if err != nil {
+		return r, false, err
+	}
+	if relative {
+		r.style = "relative"
+	}
+	var ok bool
+	r.dest, ok = relocated(dest, oldDir, newDir)
+	return r, ok, nil
+}
+
+// relink replaces the link of the relocation with one to its new destination,
+// giving it back its owner and group if `root` is true.
+func (r relocation) relink(root bool) error {
+	link := r.file.Target
+	err := os.Remove(link)
+	if err != nil {
+		return err
+	}
+	err = os.Symlink(linkDestination(r.dest, link, r.style), link)
+	if err != nil || !root {
+		return err
+	}
+	return chown(link, r.file.Owner, r.file.Group)
+}
+
+// relocated returns the path `p` moved from within `oldDir` to within
+// `newDir`, and if `p` was within `oldDir`.
+func relocated(p, oldDir, newDir string) (string, bool) {
+	if p == oldDir {
+		return newDir, true
+	}
+	rel, ok := strings.CutPrefix(p, oldDir+string(filepath.Separator))
+	if !ok {
+		return p, false
+	}
+	return filepath.Join(newDir, rel), true
+}
+
+// dirFile returns the file that records where the directory was when its files
+// were last deployed or relocated.
+func (s SubcmdRunner) dirFile() string {
+	return filepath.Join(s.dir, ".estragon", "dir")
+}
+
+// recordedDir returns the directory recorded in .estragon/dir.
+func (s SubcmdRunner) recordedDir() (string, error) {
+	data, err := os.ReadFile(s.dirFile())
+	if errors.Is(err, os.ErrNotExist) {
+		return "", errors.New(
+			"No directory is recorded in .estragon/dir, " +
+				"pass the directory the dots were moved from",
+		)
+	} else if err != nil {
+		return "", err
+	}
+	return filepath.Clean(strings.TrimSpace(string(data))), nil
+}
+
+// warnMoved prints a warning if the directory isn't where it was recorded to
+// be, since the owned links then point into the old directory.
+func (s SubcmdRunner) warnMoved() {
+	dir, err := s.recordedDir()
+	if err == nil && dir != s.dir {
+		fmt.Printf(
+			"Warning: the directory was moved from %s, "+
+				"run estragon relocate to update the links\n\n",
+			dir,
+		)
+	}
+}
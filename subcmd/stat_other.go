@@ -0,0 +1,17 @@
+//go:build !unix
+
+package subcmd
+
+import "io/fs"
+
+// fileOwner returns the IDs of the user and group that own the file described
+// by `info`, which this system doesn't record.
+func fileOwner(info fs.FileInfo) (uid, gid string, ok bool) {
+	return "", "", false
+}
+
+// fileDevice returns the ID of the device holding the file described by
+// `info`, which this system doesn't record.
+func fileDevice(info fs.FileInfo) (dev uint64, ok bool) {
+	return 0, false
+}
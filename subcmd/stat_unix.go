@@ -0,0 +1,31 @@
+//go:build unix
+
+package subcmd
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner returns the IDs of the user and group that own the file described
+// by `info`, if the system records them.
+func fileOwner(info fs.FileInfo) (uid, gid string, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", "", false
+	}
+	uid = strconv.FormatUint(uint64(stat.Uid), 10)
+	gid = strconv.FormatUint(uint64(stat.Gid), 10)
+	return uid, gid, true
+}
+
+// fileDevice returns the ID of the device holding the file described by
+// `info`, if the system records it.
+func fileDevice(info fs.FileInfo) (dev uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Dev), true
+}
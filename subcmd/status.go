@@ -0,0 +1,159 @@
+package subcmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// statusSubcmd prints every file owned by each of the `dots`, or by every dot
+// with owned files if there are none, along with the file it was deployed from
+// and whether it is still as it was deployed, including its mode, owner, and
+// group.
+func (s SubcmdRunner) statusSubcmd(dots []string) error {
+	ownJson := filepath.Join(s.dir, ".estragon", "own.json")
+	own := OwnershipManager{ownJson, false}
+
+	dotOwn, err := own.OwnedFiles()
+	if err != nil {
+		return err
+	}
+
+	if len(dots) == 0 {
+		dots = mapKeys(dotOwn)
+		sort.Strings(dots)
+	}
+
+	for i, dot := range dots {
+		fmt.Printf("Files owned by %s:\n", dot)
+		if len(dotOwn[dot]) == 0 {
+			fmt.Println("  None")
+		}
+		_, sources, err := dotFiles(s.conf, s.dir, dot)
+		if err != nil {
+			return err
+		}
+		base := dotDir(s.conf, s.dir, dot)
+		for _, file := range dotOwn[dot] {
+			state, err := fileState(file)
+			if err != nil {
+				return err
+			}
+			if state == "linked" {
+				overlays := linkedOverlays(file, base, sources)
+				if len(overlays) > 0 {
+					state += ", but the overlay files " +
+						strings.Join(overlays, ", ") +
+						" are not deployed"
+				}
+			}
+			if state != "missing" {
+				mismatches, err := permMismatches(file)
+				if err != nil {
+					return err
+				}
+				if len(mismatches) > 0 {
+					state += ", " + strings.Join(mismatches, ", ")
+				}
+			}
+			target := file.Target
+			if file.Source != "" {
+				target += " (from " + file.Source + ")"
+			}
+			fmt.Printf("  %s: %s\n", target, state)
+		}
+		if i != len(dots)-1 {
+			fmt.Println()
+		}
+	}
+
+	return nil
+}
+
+// fileState describes the state of an owned file compared to its source.
+func fileState(file OwnedFile) (string, error) {
+	info, err := os.Lstat(file.Target)
+	if errors.Is(err, os.ErrNotExist) {
+		return "missing", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		dest, _, err := readLink(file.Target)
+		if err != nil {
+			return "", err
+		}
+		if file.Source != "" && dest != file.Source {
+			return "linked to " + dest + " instead", nil
+		}
+		if _, err := os.Stat(file.Target); errors.Is(err, os.ErrNotExist) {
+			return "broken link to " + dest, nil
+		} else if err != nil {
+			return "", err
+		}
+		return "linked", nil
+	}
+
+	if file.Source == "" || info.IsDir() {
+		return "exists", nil
+	}
+
+	prefix := ""
+	if file.Hardlink {
+		sourceInfo, err := os.Stat(file.Source)
+		if err == nil && os.SameFile(info, sourceInfo) {
+			return "hard linked", nil
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		// Editors that save by replacing a file break its hard link.
+		prefix = "no longer hard linked, "
+	}
+
+	target, err := os.ReadFile(file.Target)
+	if err != nil {
+		return "", err
+	}
+	source, err := os.ReadFile(file.Source)
+	if errors.Is(err, os.ErrNotExist) {
+		return prefix + "source is missing", nil
+	} else if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(target, source) {
+		return prefix + "modified", nil
+	}
+	return prefix + "copied", nil
+}
+
+// linkedOverlays returns the files of the overlay `sources` of a dot that are
+// inside the directory an owned file links to, where `base` is the dot's source
+// directory. A directory linked whole hides any overlay of the files in it.
+func linkedOverlays(
+	file OwnedFile,
+	base string,
+	sources map[string]string,
+) []string {
+	if info, err := os.Stat(file.Target); err != nil || !info.IsDir() {
+		return nil
+	}
+	rel, err := filepath.Rel(base, file.Source)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+
+	overlays := make([]string, 0)
+	for f, source := range sources {
+		f = filepath.FromSlash(f)
+		if rel == "." || strings.HasPrefix(f, rel+string(filepath.Separator)) {
+			overlays = append(overlays, source)
+		}
+	}
+	sort.Strings(overlays)
+	return overlays
+}
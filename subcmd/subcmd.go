@@ -13,13 +13,17 @@ import (
 )
 
 type SubcmdRunner struct {
-	conf       config.Config
-	dir        string
-	dry, force bool
+	conf               config.Config
+	dir                string
+	dry, force, strict bool
 }
 
-func NewSubcmdRunner(conf config.Config, dir string, dry, force bool) SubcmdRunner {
-	return SubcmdRunner{conf, dir, dry, force}
+func NewSubcmdRunner(
+	conf config.Config,
+	dir string,
+	dry, force, strict bool,
+) SubcmdRunner {
+	return SubcmdRunner{conf, dir, dry, force, strict}
 }
 
 func (s SubcmdRunner) RunSubcmd(subcmd string, dots []string) error {
@@ -29,16 +33,19 @@ func (s SubcmdRunner) RunSubcmd(subcmd string, dots []string) error {
 	}
 
 	if subcmd != "envvar" {
-		envvars, err := s.getEnvvars()
+		err := s.setEnvvars()
 		if err != nil {
 			return err
 		}
-		for k, v := range envvars {
-			err := os.Setenv(k, v)
-			if err != nil {
-				return err
-			}
-		}
+	}
+
+	if subcmd != "envvar" && subcmd != "relocate" {
+		s.warnMoved()
+	}
+
+	dots, err = s.orderDots(subcmd, dots)
+	if err != nil {
+		return err
 	}
 
 	switch subcmd {
@@ -54,13 +61,26 @@ func (s SubcmdRunner) RunSubcmd(subcmd string, dots []string) error {
 		return s.undeploySubcmd(dots)
 	case "redeploy":
 		fmt.Print("Undeploying dots\n\n")
-		err := s.undeploySubcmd(dots)
+		reversed := make([]string, 0, len(dots))
+		for i := len(dots) - 1; i >= 0; i-- {
+			reversed = append(reversed, dots[i])
+		}
+		err := s.undeploySubcmd(reversed)
 		if err != nil {
 			return err
 		}
 		fmt.Print("\n\n")
 		fmt.Print("Deploying dots\n\n")
 		return s.deploySubcmd(dots)
+	case "explain":
+		return s.explainSubcmd(dots)
+	case "ls":
+		return s.lsSubcmd(dots)
+	case "status":
+		return s.statusSubcmd(dots)
+	case "relocate":
+		// The arguments are the directory the dots were moved from.
+		return s.relocateSubcmd(dots)
 	case "envvar":
 		envvars, err := s.getEnvvars()
 		if err != nil {
@@ -75,6 +95,75 @@ func (s SubcmdRunner) RunSubcmd(subcmd string, dots []string) error {
 	}
 }
 
+// orderDots orders the `dots` that the subcommand `subcmd` runs on by their
+// requirements. Installing and deploying also includes every required dot, and
+// skips the dots that don't apply to the environment or have unmet guards.
+func (s SubcmdRunner) orderDots(
+	subcmd string,
+	dots []string,
+) ([]string, error) {
+	switch subcmd {
+	case "install", "deploy", "redeploy":
+		ordered, err := s.conf.DependencyOrder(dots)
+		if err != nil {
+			return nil, err
+		}
+		return s.applicableDots(ordered)
+	case "undeploy":
+		return s.undeployOrder(dots)
+	default:
+		return dots, nil
+	}
+}
+
+// applicableDots returns the `dots` that apply to the environment and have
+// their guards met, printing why each of the others is skipped. In strict mode,
+// a dot with an unmet guard is an error instead.
+func (s SubcmdRunner) applicableDots(dots []string) ([]string, error) {
+	applicable := make([]string, 0, len(dots))
+	skipped := false
+	for _, dot := range dots {
+		reason, guarded, err := s.skipReason(dot)
+		if err != nil {
+			return nil, err
+		} else if reason == "" {
+			applicable = append(applicable, dot)
+			continue
+		}
+
+		if guarded && s.strict {
+			return nil, fmt.Errorf("Dot %s can't be used because %s", dot, reason)
+		}
+		fmt.Printf("Skipping dot %s because %s\n", dot, reason)
+		skipped = true
+	}
+	if skipped {
+		fmt.Println()
+	}
+	return applicable, nil
+}
+
+// undeployOrder orders the `dots` so that each dot is undeployed before the
+// dots it requires, without adding the required dots themselves.
+func (s SubcmdRunner) undeployOrder(dots []string) ([]string, error) {
+	ordered, err := s.conf.DependencyOrder(dots)
+	if err != nil {
+		return nil, err
+	}
+
+	given := make(map[string]bool)
+	for _, d := range dots {
+		given[d] = true
+	}
+	reversed := make([]string, 0, len(dots))
+	for i := len(ordered) - 1; i >= 0; i-- {
+		if given[ordered[i]] {
+			reversed = append(reversed, ordered[i])
+		}
+	}
+	return reversed, nil
+}
+
 func runCmd(args []string) (int, error) {
 	cmd := exec.Command(args[0], args[1:]...)
 	err := cmd.Run()
@@ -94,17 +183,7 @@ func (s SubcmdRunner) deploySubcmd(dots []string) error {
 	ownJson := filepath.Join(s.dir, ".estragon", "own.json")
 	own := OwnershipManager{ownJson, s.force}
 	for i, dot := range dots {
-		conf := s.conf.DotConfig(dot)
-		root := filepath.Join(s.dir, dot)
-		deployer := NewDotfileDeployer(
-			conf,
-			root,
-			pathExpander{dot}.expand,
-			own,
-			s.dry,
-		)
-
-		files, err := dirFiles(root)
+		deployer, files, err := dotDeployer(s.conf, s.dir, dot, own, s.dry)
 		if err != nil {
 			return err
 		}
@@ -129,6 +208,43 @@ func (s SubcmdRunner) deploySubcmd(dots []string) error {
 	return nil
 }
 
+// dotDir returns the directory containing the files of the dot `dot` in the
+// config directory `dir`.
+// dotDeployer returns the deployer of the dot `dot` in the config directory
+// `dir` under the config `conf`, with the sources, alternates, and ignored
+// files of the dot, along with the files that it deploys.
+func dotDeployer(
+	conf config.Config,
+	dir, dot string,
+	own OwnershipManager,
+	dry bool,
+) (DotfileDeployer, []string, error) {
+	files, sources, err := dotFiles(conf, dir, dot)
+	if err != nil {
+		return DotfileDeployer{}, nil, err
+	}
+	ignorer, err := dotIgnorer(conf, dir, dot, files, sources)
+	if err != nil {
+		return DotfileDeployer{}, nil, err
+	}
+
+	deployer := NewDotfileDeployer(
+		conf.DotConfig(dot),
+		dotDir(conf, dir, dot),
+		pathExpander{dot}.expand,
+		own,
+		dry,
+	)
+	deployer = deployer.WithSources(sources).WithAlternates(
+		conf.SelectAlternate,
+	).WithIgnorer(ignorer)
+	return deployer, files, nil
+}
+
+func dotDir(conf config.Config, dir, dot string) string {
+	return filepath.Join(dir, filepath.FromSlash(conf.Source(dot)))
+}
+
 func dirFiles(dotDir string) ([]string, error) {
 	if _, err := os.Stat(dotDir); errors.Is(err, os.ErrNotExist) {
 		// Don't try to walk or an error occurs. It's possible to want
@@ -151,6 +267,10 @@ func dirFiles(dotDir string) ([]string, error) {
 				return err
 			}
 			relPath = filepath.ToSlash(relPath)
+			if relPath == "estragon.yaml" {
+				// The config fragment of the dot isn't deployed.
+				return nil
+			}
 			files = append(files, relPath)
 		}
 		return nil
@@ -173,6 +293,10 @@ func (p pathExpander) expand(s string) (string, error) {
 	s = strings.ReplaceAll(s, "~", home)
 	s = strings.ReplaceAll(s, "*", p.dot)
 	s = os.Expand(s, func(k string) string {
+		if k == "$" {
+			// An escaped dollar sign.
+			return "$"
+		}
 		e, varExists := os.LookupEnv(k)
 		if err == nil && !varExists {
 			err = errors.New(
@@ -200,6 +324,22 @@ func (s SubcmdRunner) undeploySubcmd(dots []string) error {
 	return nil
 }
 
+// setEnvvars sets the environment variables saved with the envvar subcommand
+// in the environment of the process.
+func (s SubcmdRunner) setEnvvars() error {
+	envvars, err := s.getEnvvars()
+	if err != nil {
+		return err
+	}
+	for k, v := range envvars {
+		err := os.Setenv(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s SubcmdRunner) getEnvvars() (map[string]string, error) {
 	varMap := make(map[string]string)
 
@@ -238,7 +378,7 @@ func (s SubcmdRunner) printOwnership(dots []string) error {
 
 	if len(dots) > 0 {
 		allOwnedDots := dotOwn
-		dotOwn = make(map[string][]string)
+		dotOwn = make(map[string][]OwnedFile)
 		for _, dot := range dots {
 			dotOwn[dot] = allOwnedDots[dot]
 		}
@@ -247,7 +387,7 @@ func (s SubcmdRunner) printOwnership(dots []string) error {
 	for dot, owned := range dotOwn {
 		fmt.Printf("Files owned by %s:\n", dot)
 		for _, o := range owned {
-			fmt.Printf("  %s\n", o)
+			fmt.Printf("  %s\n", o.Target)
 		}
 	}
 
@@ -0,0 +1,230 @@
+package subcmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aus-hawk/estragon/env"
+	"gopkg.in/yaml.v3"
+)
+
+// A testCase is a single case of a config test file. The environment is
+// either an environment string or the name of a profile in the config.
+type testCase struct {
+	Name string
+	Env  string
+	Dots map[string]dotExpectation
+}
+
+// A dotExpectation is what a dot is expected to do in a test case. Only the
+// values that are set are checked. Applies is whether the dot applies to the
+// environment. The packages map package names to their
+// expected expansion, and the files map paths relative to the dot directory to
+// where they are expected to be deployed, with an empty string meaning that
+// the file is not deployed and a list meaning that it is deployed to each of
+// the targets in it.
+type dotExpectation struct {
+	Applies  *bool
+	Method   string
+	Packages map[string][]string
+	Files    map[string]fileTargets
+	Deploy   [][]string
+}
+
+// fileTargets are the targets that a file is expected to be deployed to, which
+// are either a single target or a list of them.
+type fileTargets []string
+
+func (f *fileTargets) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.SequenceNode {
+		return n.Decode((*[]string)(f))
+	}
+	var single string
+	err := n.Decode(&single)
+	*f = fileTargets{single}
+	return err
+}
+
+// Test evaluates every test case in the test `files` against the config
+// without changing the system, printing each failure with what was expected and
+// what was found. If any test case fails, a non-nil error is returned.
+func (s SubcmdRunner) Test(files []string) error {
+	err := s.setEnvvars()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if len(files) == 0 {
+		files = []string{filepath.Join(s.dir, "estragon.test.yaml")}
+	}
+
+	total, failed := 0, 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		// Unknown keys are rejected, since a misspelled expectation
+		// would otherwise never be checked.
+		var cases []testCase
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&cases)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		for _, c := range cases {
+			total++
+			failures := s.runTestCase(c)
+			if len(failures) == 0 {
+				fmt.Printf("PASS %s\n", c.Name)
+				continue
+			}
+
+			failed++
+			fmt.Printf("FAIL %s\n", c.Name)
+			for _, f := range failures {
+				fmt.Println(f)
+			}
+		}
+	}
+
+	fmt.Printf("\n%d of %d test cases passed\n", total-failed, total)
+	if failed > 0 {
+		return fmt.Errorf("%d test cases failed", failed)
+	}
+	return nil
+}
+
+// runTestCase returns a description of every way that the config does not do
+// what the test case expects.
+func (s SubcmdRunner) runTestCase(c testCase) []string {
+	envString := c.Env
+	if profile, ok := s.conf.Profiles()[c.Env]; ok {
+		envString = profile
+	}
+	conf := s.conf.WithSelector(env.NewEnvironment(envString))
+
+	var failures []string
+	fail := func(dot, what string, expected, actual any) {
+		failures = append(failures, fmt.Sprintf(
+			"  %s %s:\n    - %v\n    + %v",
+			dot,
+			what,
+			expected,
+			actual,
+		))
+	}
+
+	if err := conf.ValidateEnv(); err != nil {
+		failures = append(failures, "  "+err.Error())
+	}
+
+	dots := mapKeys(c.Dots)
+	sort.Strings(dots)
+	for _, dot := range dots {
+		expected := c.Dots[dot]
+		plan, err := planDot(conf, s.dir, dot)
+		if err != nil {
+			failures = append(failures, "  "+dot+": "+err.Error())
+			continue
+		}
+
+		if expected.Applies != nil {
+			if ok, _ := conf.Applies(dot); ok != *expected.Applies {
+				fail(dot, "applies", *expected.Applies, ok)
+			}
+		}
+
+		if expected.Method != "" && expected.Method != plan.method {
+			fail(dot, "method", expected.Method, plan.method)
+		}
+
+		pkgs := mapKeys(expected.Packages)
+		sort.Strings(pkgs)
+		for _, pkg := range pkgs {
+			actual, ok := plan.packages[pkg]
+			if !ok {
+				fail(dot, "package "+pkg, expected.Packages[pkg], "not a package")
+			} else if !reflect.DeepEqual(expected.Packages[pkg], actual) {
+				fail(dot, "package "+pkg, expected.Packages[pkg], actual)
+			}
+		}
+
+		files := mapKeys(expected.Files)
+		sort.Strings(files)
+		expand := pathExpander{dot}.expand
+		for _, file := range files {
+			targets := make([]string, 0, len(expected.Files[file]))
+			var expandErr error
+			for _, target := range expected.Files[file] {
+				if target == "" {
+					continue
+				}
+				target, expandErr = expand(target)
+				if expandErr != nil {
+					break
+				}
+				targets = append(targets, target)
+			}
+			if expandErr != nil {
+				failures = append(failures, "  "+dot+": "+expandErr.Error())
+				continue
+			}
+			sort.Strings(targets)
+
+			expectedFile := strings.Join(targets, ", ")
+			if actual := plan.files[file]; actual != expectedFile {
+				fail(
+					dot,
+					"file "+file,
+					describeTarget(expectedFile),
+					describeTarget(actual),
+				)
+			}
+		}
+
+		if expected.Deploy != nil {
+			expectedDeploy := make([]string, 0, len(expected.Deploy))
+			var expandErr error
+			for _, cmd := range expected.Deploy {
+				var expandedCmd []string
+				expandedCmd, expandErr = expandArgs(cmd, expand)
+				if expandErr != nil {
+					break
+				}
+				expectedDeploy = append(
+					expectedDeploy,
+					strings.Join(expandedCmd, " "),
+				)
+			}
+			if expandErr != nil {
+				failures = append(failures, "  "+dot+": "+expandErr.Error())
+				continue
+			}
+			expectedCmds := strings.Join(expectedDeploy, "; ")
+			actualCmds := strings.Join(plan.deploy, "; ")
+			if expectedCmds != actualCmds {
+				fail(dot, "deploy", expectedCmds, actualCmds)
+			}
+		}
+	}
+
+	return failures
+}
+
+func describeTarget(target string) string {
+	if target == "" {
+		return "(not deployed)"
+	}
+	return target
+}
@@ -26,23 +26,44 @@ func (d DotfileUndeployer) Undeploy(dot string) error {
 		return nil
 	}
 
+	kept := make([]OwnedFile, 0)
 	for _, file := range files {
-		fmt.Println("  Removing file", file)
+		if file.Hardlink && !sharesInode(file) {
+			// The file was replaced since it was deployed, so it may
+			// hold changes that its source doesn't. It stays owned so
+			// that it can still be found and removed.
+			fmt.Println(
+				"  Keeping file",
+				file.Target,
+				"since it is no longer hard linked to",
+				file.Source,
+			)
+			kept = append(kept, file)
+			continue
+		}
+
+		fmt.Println("  Removing file", file.Target)
 		if !d.dry {
-			err = os.Remove(file)
-			if err != nil {
+			err = os.Remove(file.Target)
+			if errors.Is(err, os.ErrNotExist) {
+				// A kept file that was removed by hand.
+				err = nil
+			} else if err != nil {
 				return err
 			}
 
-			err = removeEmptyParents(file)
+			err = removeEmptyParents(file.Target)
 			if err != nil {
 				return err
 			}
 		}
 	}
 
-	if !d.dry {
-		d.own.DisownDot(dot)
+	if !d.dry && len(kept) > 0 {
+		owned[dot] = kept
+		err = d.own.write(owned)
+	} else if !d.dry {
+		err = d.own.DisownDot(dot)
 	} else {
 		fmt.Println()
 		fmt.Println("Directories that would be empty after these removals")
@@ -52,11 +73,27 @@ func (d DotfileUndeployer) Undeploy(dot string) error {
 	return err
 }
 
+// sharesInode returns if the target of `file` is the same file as its source,
+// which is the case for a hard link that hasn't been replaced. A target that
+// doesn't exist isn't considered replaced.
+func sharesInode(file OwnedFile) bool {
+	info, err := os.Lstat(file.Target)
+	if err != nil {
+		return errors.Is(err, os.ErrNotExist)
+	}
+	sourceInfo, err := os.Stat(file.Source)
+	return err == nil && os.SameFile(info, sourceInfo)
+}
+
 func removeEmptyParents(file string) error {
 	dir := filepath.Dir(file)
 	for dir != "." {
 		dirFile, err := os.Open(dir)
-		if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// It was already removed along with the file.
+			dir = filepath.Dir(dir)
+			continue
+		} else if err != nil {
 			return err
 		}
 		defer dirFile.Close()